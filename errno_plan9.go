@@ -0,0 +1,33 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Plan 9 reports errors as strings and its syscall package lacks these.
+var (
+	errBadFd        = syscall.NewError("bad file descriptor")
+	errFileTooLarge = syscall.NewError("file too large")
+	errNotEmpty     = syscall.NewError("directory not empty")
+	errNoData       = syscall.NewError("no data available")
+	errNotSupported = errors.ErrUnsupported
+	errRange        = syscall.NewError("numerical result out of range")
+	errReadOnly     = syscall.NewError("read-only file system")
+	errSymlinkLoop  = syscall.NewError("too many levels of symbolic links")
+	errTooBig       = syscall.NewError("argument list too long")
+)
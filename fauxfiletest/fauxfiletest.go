@@ -0,0 +1,732 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fauxfiletest checks that a fauxfile.Filesystem behaves the way
+// the operating system's filesystem does, so that implementations can be
+// used interchangeably in tests.
+package fauxfiletest
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kurrik/fauxfile"
+)
+
+// Returns a new Filesystem along with the path of an existing, empty
+// directory within it which the tests may use freely.  Called once for
+// each test.
+type Factory func(t *testing.T) (fsys fauxfile.Filesystem, dir string)
+
+// Runs every conformance test against filesystems created by factory,
+// each as a subtest.  Tests which change the working directory of the
+// Filesystem restore it afterwards.
+func TestFilesystem(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		run  func(s *suite)
+	}{
+		{"Mkdir", testMkdir},
+		{"MkdirAll", testMkdirAll},
+		{"CreateOpen", testCreateOpen},
+		{"OpenFile", testOpenFile},
+		{"Remove", testRemove},
+		{"RemoveAll", testRemoveAll},
+		{"Rename", testRename},
+		{"Symlink", testSymlink},
+		{"Link", testLink},
+		{"Chtimes", testChtimes},
+		{"Chmod", testChmod},
+		{"Chown", testChown},
+		{"ChdirGetwd", testChdirGetwd},
+		{"Truncate", testTruncate},
+		{"ReadDir", testReadDir},
+		{"ReadWriteFile", testReadWriteFile},
+		{"Temp", testTemp},
+		{"ReadWriteSeek", testReadWriteSeek},
+		{"ReadAtWriteAt", testReadAtWriteAt},
+		{"Readdir", testReaddir},
+		{"FileStat", testFileStat},
+		{"Closed", testClosed},
+		{"FdSyscallConn", testFdSyscallConn},
+		{"Deadline", testDeadline},
+		{"ReadFromWriteTo", testReadFromWriteTo},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fsys, dir := factory(t)
+			test.run(&suite{t: t, fsys: fsys, dir: dir})
+		})
+	}
+}
+
+type suite struct {
+	t    *testing.T
+	fsys fauxfile.Filesystem
+	dir  string
+}
+
+// Returns the path of name within the test directory.
+func (s *suite) path(name string) string {
+	return path.Join(s.dir, name)
+}
+
+func (s *suite) check(err error) {
+	s.t.Helper()
+	if err != nil {
+		s.t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func (s *suite) create(name string, contents string) {
+	s.t.Helper()
+	s.check(s.fsys.WriteFile(s.path(name), []byte(contents), 0644))
+}
+
+func (s *suite) expectContents(name string, expected string) {
+	s.t.Helper()
+	data, err := s.fsys.ReadFile(s.path(name))
+	if err != nil {
+		s.t.Fatalf("Could not read %v: %v", name, err)
+	}
+	if string(data) != expected {
+		s.t.Fatalf("Contents of %v are %q, expected %q", name, data, expected)
+	}
+}
+
+func (s *suite) expectError(expected error, err error) {
+	s.t.Helper()
+	if !errors.Is(err, expected) {
+		s.t.Fatalf("Expected error '%v', got '%v'", expected, err)
+	}
+}
+
+func (s *suite) expectPathError(op string, name string, expected error, err error) {
+	s.t.Helper()
+	var perr *fs.PathError
+	if !errors.As(err, &perr) {
+		s.t.Fatalf("Expected *fs.PathError, got %#v", err)
+	}
+	if perr.Op != op || perr.Path != name {
+		s.t.Fatalf("Expected error for %v %v, got %v", op, name, err)
+	}
+	s.expectError(expected, err)
+}
+
+func (s *suite) expectFailure(err error, format string, args ...interface{}) {
+	s.t.Helper()
+	if err == nil {
+		s.t.Fatalf("Expected error: "+format, args...)
+	}
+}
+
+func (s *suite) stat(name string) os.FileInfo {
+	s.t.Helper()
+	fi, err := s.fsys.Stat(s.path(name))
+	if err != nil {
+		s.t.Fatalf("Could not stat %v: %v", name, err)
+	}
+	return fi
+}
+
+func (s *suite) expectDir(name string) {
+	s.t.Helper()
+	if fi := s.stat(name); !fi.IsDir() {
+		s.t.Fatalf("Expected %v to be a directory, mode %v", name, fi.Mode())
+	}
+}
+
+func (s *suite) expectNotExist(name string) {
+	s.t.Helper()
+	_, err := s.fsys.Lstat(s.path(name))
+	s.expectError(fs.ErrNotExist, err)
+}
+
+func (s *suite) expectPerm(name string, expected os.FileMode) {
+	s.t.Helper()
+	if perm := s.stat(name).Mode().Perm(); perm != expected {
+		s.t.Fatalf("Perm of %v is %v, expected %v", name, perm, expected)
+	}
+}
+
+func (s *suite) open(name string, flag int) fauxfile.File {
+	s.t.Helper()
+	f, err := s.fsys.OpenFile(s.path(name), flag, 0644)
+	if err != nil {
+		s.t.Fatalf("Could not open %v: %v", name, err)
+	}
+	s.t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func (s *suite) expectRead(f fauxfile.File, expected string) {
+	s.t.Helper()
+	b := make([]byte, len(expected))
+	if n, err := io.ReadFull(f, b); err != nil {
+		s.t.Fatalf("Read returned %v, %v", n, err)
+	}
+	if string(b) != expected {
+		s.t.Fatalf("Read %q, expected %q", b, expected)
+	}
+}
+
+func (s *suite) expectSeek(f fauxfile.File, offset int64, whence int, expected int64) {
+	s.t.Helper()
+	ret, err := f.Seek(offset, whence)
+	if err != nil || ret != expected {
+		s.t.Fatalf("Seek(%v, %v) returned %v, %v, expected %v", offset, whence, ret, err, expected)
+	}
+}
+
+func testMkdir(s *suite) {
+	s.check(s.fsys.Mkdir(s.path("a"), 0700))
+	s.expectDir("a")
+	s.expectPerm("a", 0700)
+	s.expectPathError("mkdir", s.path("a"), fs.ErrExist, s.fsys.Mkdir(s.path("a"), 0700))
+	s.expectPathError("mkdir", s.path("b/c"), fs.ErrNotExist, s.fsys.Mkdir(s.path("b/c"), 0700))
+	s.create("f", "")
+	s.expectPathError("mkdir", s.path("f/c"), syscall.ENOTDIR, s.fsys.Mkdir(s.path("f/c"), 0700))
+}
+
+func testMkdirAll(s *suite) {
+	s.check(s.fsys.MkdirAll(s.path("a/b/c"), 0755))
+	s.expectDir("a/b/c")
+	s.check(s.fsys.MkdirAll(s.path("a/b/c"), 0755))
+	s.check(s.fsys.MkdirAll(s.path("a"), 0755))
+	s.create("a/f", "")
+	s.expectError(syscall.ENOTDIR, s.fsys.MkdirAll(s.path("a/f/g"), 0755))
+}
+
+func testCreateOpen(s *suite) {
+	f, err := s.fsys.Create(s.path("foo.txt"))
+	s.check(err)
+	if f.Name() != s.path("foo.txt") {
+		s.t.Fatalf("Name returned %v, expected %v", f.Name(), s.path("foo.txt"))
+	}
+	_, err = f.WriteString("hello")
+	s.check(err)
+	s.check(f.Close())
+	s.expectContents("foo.txt", "hello")
+	f, err = s.fsys.Open(s.path("foo.txt"))
+	s.check(err)
+	s.expectRead(f, "hello")
+	s.check(f.Close())
+	f, err = s.fsys.Create(s.path("foo.txt"))
+	s.check(err)
+	s.check(f.Close())
+	s.expectContents("foo.txt", "")
+	_, err = s.fsys.Open(s.path("missing"))
+	s.expectPathError("open", s.path("missing"), fs.ErrNotExist, err)
+	s.check(s.fsys.Mkdir(s.path("dir"), 0755))
+	_, err = s.fsys.Create(s.path("dir"))
+	s.expectPathError("open", s.path("dir"), syscall.EISDIR, err)
+}
+
+func testOpenFile(s *suite) {
+	f, err := s.fsys.OpenFile(s.path("foo.txt"), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	s.check(err)
+	f.WriteString("hello")
+	f.Close()
+	s.expectPerm("foo.txt", 0600)
+	_, err = s.fsys.OpenFile(s.path("foo.txt"), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	s.expectPathError("open", s.path("foo.txt"), fs.ErrExist, err)
+	_, err = s.fsys.OpenFile(s.path("missing"), os.O_RDWR, 0)
+	s.expectError(fs.ErrNotExist, err)
+
+	f = s.open("foo.txt", os.O_WRONLY|os.O_APPEND)
+	f.WriteString(" world")
+	s.expectContents("foo.txt", "hello world")
+	_, err = f.Read(make([]byte, 1))
+	s.expectFailure(err, "Read on a write-only file")
+
+	f = s.open("foo.txt", os.O_RDONLY)
+	_, err = f.Write([]byte("x"))
+	s.expectFailure(err, "Write on a read-only file")
+
+	s.open("foo.txt", os.O_WRONLY|os.O_TRUNC)
+	s.expectContents("foo.txt", "")
+
+	s.check(s.fsys.Mkdir(s.path("dir"), 0755))
+	_, err = s.fsys.OpenFile(s.path("dir"), os.O_RDWR, 0)
+	s.expectError(syscall.EISDIR, err)
+	f = s.open("dir", os.O_RDONLY)
+	_, err = f.Read(make([]byte, 1))
+	s.expectError(syscall.EISDIR, err)
+}
+
+func testRemove(s *suite) {
+	s.create("foo.txt", "")
+	s.check(s.fsys.Remove(s.path("foo.txt")))
+	s.expectNotExist("foo.txt")
+	s.check(s.fsys.MkdirAll(s.path("a/b"), 0755))
+	s.expectPathError("remove", s.path("a"), errNotEmpty, s.fsys.Remove(s.path("a")))
+	s.check(s.fsys.Remove(s.path("a/b")))
+	s.check(s.fsys.Remove(s.path("a")))
+	s.expectNotExist("a")
+	s.expectPathError("remove", s.path("a"), fs.ErrNotExist, s.fsys.Remove(s.path("a")))
+}
+
+func testRemoveAll(s *suite) {
+	s.check(s.fsys.MkdirAll(s.path("a/b/c"), 0755))
+	s.create("a/b/c/foo.txt", "")
+	s.create("a/bar.txt", "")
+	s.check(s.fsys.RemoveAll(s.path("a")))
+	s.expectNotExist("a")
+	s.check(s.fsys.RemoveAll(s.path("a")))
+	s.create("foo.txt", "")
+	s.check(s.fsys.RemoveAll(s.path("foo.txt")))
+	s.expectNotExist("foo.txt")
+}
+
+func testRename(s *suite) {
+	s.create("a.txt", "a")
+	s.create("b.txt", "b")
+	s.check(s.fsys.Rename(s.path("a.txt"), s.path("c.txt")))
+	s.expectNotExist("a.txt")
+	s.expectContents("c.txt", "a")
+	s.check(s.fsys.Rename(s.path("c.txt"), s.path("b.txt")))
+	s.expectContents("b.txt", "a")
+
+	s.check(s.fsys.MkdirAll(s.path("d/e"), 0755))
+	s.create("d/e/f.txt", "f")
+	s.check(s.fsys.Rename(s.path("d"), s.path("g")))
+	s.expectContents("g/e/f.txt", "f")
+	s.expectError(syscall.EINVAL, s.fsys.Rename(s.path("g"), s.path("g/e/h")))
+	// os.Rename refuses to replace any directory, where rename(2) gives
+	// EISDIR, so only the failure is checked.
+	s.expectFailure(s.fsys.Rename(s.path("b.txt"), s.path("g")), "Rename of a file over a directory")
+	s.expectError(syscall.ENOTDIR, s.fsys.Rename(s.path("g"), s.path("b.txt")))
+	s.check(s.fsys.Mkdir(s.path("h"), 0755))
+	s.expectFailure(s.fsys.Rename(s.path("h"), s.path("g")), "Rename over a non-empty directory")
+	s.check(s.fsys.Rename(s.path("g/e"), s.path("h/i")))
+	s.expectContents("h/i/f.txt", "f")
+
+	err := s.fsys.Rename(s.path("missing"), s.path("x"))
+	var lerr *os.LinkError
+	if !errors.As(err, &lerr) {
+		s.t.Fatalf("Expected *os.LinkError, got %#v", err)
+	}
+	s.expectError(fs.ErrNotExist, err)
+}
+
+func testSymlink(s *suite) {
+	s.create("target.txt", "hello")
+	s.check(s.fsys.Symlink("target.txt", s.path("link")))
+	fi := s.stat("link")
+	if !fi.Mode().IsRegular() || fi.Size() != 5 {
+		s.t.Fatalf("Stat should follow the link, got mode %v size %v", fi.Mode(), fi.Size())
+	}
+	lfi, err := s.fsys.Lstat(s.path("link"))
+	s.check(err)
+	if lfi.Mode()&os.ModeSymlink == 0 {
+		s.t.Fatalf("Lstat should not follow the link, got mode %v", lfi.Mode())
+	}
+	target, err := s.fsys.Readlink(s.path("link"))
+	if err != nil || target != "target.txt" {
+		s.t.Fatalf("Readlink returned %v, %v", target, err)
+	}
+	s.expectContents("link", "hello")
+	_, err = s.fsys.Readlink(s.path("target.txt"))
+	s.expectPathError("readlink", s.path("target.txt"), syscall.EINVAL, err)
+
+	s.check(s.fsys.Symlink("missing", s.path("dangling")))
+	_, err = s.fsys.Stat(s.path("dangling"))
+	s.expectError(fs.ErrNotExist, err)
+	_, err = s.fsys.Lstat(s.path("dangling"))
+	s.check(err)
+	s.expectError(fs.ErrExist, s.fsys.Symlink("target.txt", s.path("link")))
+
+	s.check(s.fsys.Mkdir(s.path("dir"), 0755))
+	s.check(s.fsys.Symlink("dir", s.path("dirlink")))
+	s.create("dirlink/inside.txt", "inside")
+	s.expectContents("dir/inside.txt", "inside")
+	s.check(s.fsys.Remove(s.path("dirlink")))
+	s.expectContents("dir/inside.txt", "inside")
+}
+
+func testLink(s *suite) {
+	s.create("a.txt", "hello")
+	s.check(s.fsys.Link(s.path("a.txt"), s.path("b.txt")))
+	s.expectContents("b.txt", "hello")
+	f := s.open("b.txt", os.O_WRONLY|os.O_APPEND)
+	f.WriteString(" world")
+	s.expectContents("a.txt", "hello world")
+	s.check(s.fsys.Remove(s.path("a.txt")))
+	s.expectContents("b.txt", "hello world")
+	s.create("c.txt", "")
+	s.expectError(fs.ErrExist, s.fsys.Link(s.path("b.txt"), s.path("c.txt")))
+	s.expectError(fs.ErrNotExist, s.fsys.Link(s.path("a.txt"), s.path("d.txt")))
+}
+
+func testChtimes(s *suite) {
+	s.create("foo.txt", "")
+	atime := time.Unix(1000000000, 0)
+	mtime := time.Unix(1100000000, 0)
+	s.check(s.fsys.Chtimes(s.path("foo.txt"), atime, mtime))
+	if modtime := s.stat("foo.txt").ModTime(); !modtime.Equal(mtime) {
+		s.t.Fatalf("ModTime is %v, expected %v", modtime, mtime)
+	}
+	s.check(s.fsys.Chtimes(s.path("foo.txt"), time.Time{}, time.Time{}))
+	if modtime := s.stat("foo.txt").ModTime(); !modtime.Equal(mtime) {
+		s.t.Fatalf("Zero times should leave ModTime alone, got %v", modtime)
+	}
+	err := s.fsys.Chtimes(s.path("missing"), atime, mtime)
+	s.expectPathError("chtimes", s.path("missing"), fs.ErrNotExist, err)
+}
+
+func testChmod(s *suite) {
+	s.create("foo.txt", "")
+	s.check(s.fsys.Chmod(s.path("foo.txt"), 0600))
+	s.expectPerm("foo.txt", 0600)
+	f := s.open("foo.txt", os.O_RDONLY)
+	s.check(f.Chmod(0640))
+	s.expectPerm("foo.txt", 0640)
+	s.check(s.fsys.Mkdir(s.path("dir"), 0755))
+	s.check(s.fsys.Chmod(s.path("dir"), 0700))
+	s.expectDir("dir")
+	s.expectPerm("dir", 0700)
+	err := s.fsys.Chmod(s.path("missing"), 0600)
+	s.expectPathError("chmod", s.path("missing"), fs.ErrNotExist, err)
+}
+
+func testChown(s *suite) {
+	s.create("foo.txt", "")
+	s.check(s.fsys.Symlink("foo.txt", s.path("link")))
+	s.check(s.fsys.Chown(s.path("foo.txt"), -1, -1))
+	s.check(s.fsys.Lchown(s.path("link"), -1, -1))
+	f := s.open("foo.txt", os.O_RDONLY)
+	s.check(f.Chown(-1, -1))
+	err := s.fsys.Chown(s.path("missing"), -1, -1)
+	s.expectPathError("chown", s.path("missing"), fs.ErrNotExist, err)
+	err = s.fsys.Lchown(s.path("missing"), -1, -1)
+	s.expectPathError("lchown", s.path("missing"), fs.ErrNotExist, err)
+}
+
+func testChdirGetwd(s *suite) {
+	old, err := s.fsys.Getwd()
+	s.check(err)
+	s.t.Cleanup(func() { s.fsys.Chdir(old) })
+	s.check(s.fsys.MkdirAll(s.path("a/b"), 0755))
+	s.create("a/marker.txt", "a")
+	s.create("a/b/marker.txt", "b")
+
+	s.check(s.fsys.Chdir(s.path("a")))
+	wd, err := s.fsys.Getwd()
+	s.check(err)
+	if !path.IsAbs(wd) {
+		s.t.Fatalf("Getwd returned relative path %v", wd)
+	}
+	if data, err := s.fsys.ReadFile(path.Join(wd, "marker.txt")); err != nil || string(data) != "a" {
+		s.t.Fatalf("Getwd returned %v, which does not contain marker.txt", wd)
+	}
+	if data, err := s.fsys.ReadFile("marker.txt"); err != nil || string(data) != "a" {
+		s.t.Fatalf("Relative ReadFile returned %q, %v", data, err)
+	}
+	s.check(s.fsys.Chdir("b"))
+	if data, err := s.fsys.ReadFile("marker.txt"); err != nil || string(data) != "b" {
+		s.t.Fatalf("Relative ReadFile returned %q, %v", data, err)
+	}
+	s.check(s.fsys.Chdir(".."))
+	s.expectPathError("chdir", "marker.txt", syscall.ENOTDIR, s.fsys.Chdir("marker.txt"))
+	s.expectPathError("chdir", "missing", fs.ErrNotExist, s.fsys.Chdir("missing"))
+
+	f := s.open("a/b", os.O_RDONLY)
+	s.check(f.Chdir())
+	if data, err := s.fsys.ReadFile("marker.txt"); err != nil || string(data) != "b" {
+		s.t.Fatalf("ReadFile after File.Chdir returned %q, %v", data, err)
+	}
+	f = s.open("a/marker.txt", os.O_RDONLY)
+	s.expectPathError("chdir", s.path("a/marker.txt"), syscall.ENOTDIR, f.Chdir())
+	if data, err := s.fsys.ReadFile("marker.txt"); err != nil || string(data) != "b" {
+		s.t.Fatalf("File.Chdir on a regular file moved to %q, %v", data, err)
+	}
+}
+
+func testTruncate(s *suite) {
+	s.create("foo.txt", "hello")
+	s.check(s.fsys.Truncate(s.path("foo.txt"), 2))
+	s.expectContents("foo.txt", "he")
+	s.check(s.fsys.Truncate(s.path("foo.txt"), 4))
+	s.expectContents("foo.txt", "he\x00\x00")
+	err := s.fsys.Truncate(s.path("missing"), 0)
+	s.expectPathError("truncate", s.path("missing"), fs.ErrNotExist, err)
+
+	f := s.open("foo.txt", os.O_RDWR)
+	s.check(f.Truncate(1))
+	s.expectContents("foo.txt", "h")
+	s.expectFailure(f.Truncate(-1), "Truncate to a negative size")
+	f = s.open("foo.txt", os.O_RDONLY)
+	s.expectFailure(f.Truncate(0), "Truncate of a read-only file")
+}
+
+func testReadDir(s *suite) {
+	s.create("c.txt", "")
+	s.create("a.txt", "")
+	s.check(s.fsys.Mkdir(s.path("b"), 0755))
+	entries, err := s.fsys.ReadDir(s.dir)
+	s.check(err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, ",") != "a.txt,b,c.txt" {
+		s.t.Fatalf("ReadDir returned %v, expected sorted entries", names)
+	}
+	if entries[0].IsDir() || !entries[1].IsDir() || entries[1].Type() != fs.ModeDir {
+		s.t.Fatalf("ReadDir returned wrong types: %v, %v", entries[0], entries[1])
+	}
+	_, err = s.fsys.ReadDir(s.path("a.txt"))
+	s.expectError(syscall.ENOTDIR, err)
+	_, err = s.fsys.ReadDir(s.path("missing"))
+	s.expectError(fs.ErrNotExist, err)
+}
+
+func testReadWriteFile(s *suite) {
+	s.check(s.fsys.WriteFile(s.path("foo.txt"), []byte("hello world"), 0600))
+	s.expectPerm("foo.txt", 0600)
+	s.check(s.fsys.WriteFile(s.path("foo.txt"), []byte("bye"), 0644))
+	s.expectContents("foo.txt", "bye")
+	s.expectPerm("foo.txt", 0600)
+	_, err := s.fsys.ReadFile(s.path("missing"))
+	s.expectPathError("open", s.path("missing"), fs.ErrNotExist, err)
+	err = s.fsys.WriteFile(s.path("missing/foo.txt"), nil, 0644)
+	s.expectError(fs.ErrNotExist, err)
+}
+
+func testTemp(s *suite) {
+	if s.fsys.TempDir() == "" {
+		s.t.Fatalf("TempDir returned an empty path")
+	}
+	name, err := s.fsys.MkdirTemp(s.dir, "pre*suf")
+	s.check(err)
+	base := path.Base(name)
+	if path.Dir(name) != path.Clean(s.dir) || !strings.HasPrefix(base, "pre") || !strings.HasSuffix(base, "suf") {
+		s.t.Fatalf("MkdirTemp returned %v", name)
+	}
+	s.expectDir(base)
+	other, err := s.fsys.MkdirTemp(s.dir, "pre*suf")
+	s.check(err)
+	if other == name {
+		s.t.Fatalf("MkdirTemp returned %v twice", name)
+	}
+	f, err := s.fsys.CreateTemp(s.dir, "*.txt")
+	s.check(err)
+	defer f.Close()
+	if path.Dir(f.Name()) != path.Clean(s.dir) || !strings.HasSuffix(f.Name(), ".txt") {
+		s.t.Fatalf("CreateTemp returned %v", f.Name())
+	}
+	_, err = f.WriteString("temp")
+	s.check(err)
+	s.expectContents(path.Base(f.Name()), "temp")
+	_, err = s.fsys.MkdirTemp(s.dir, "a/b")
+	s.expectFailure(err, "MkdirTemp pattern with a separator")
+	_, err = s.fsys.CreateTemp(s.dir, "a/b")
+	s.expectFailure(err, "CreateTemp pattern with a separator")
+}
+
+func testReadWriteSeek(s *suite) {
+	f := s.open("foo.txt", os.O_RDWR|os.O_CREATE)
+	if n, err := f.Write([]byte("hello world")); err != nil || n != 11 {
+		s.t.Fatalf("Write returned %v, %v", n, err)
+	}
+	s.expectSeek(f, 0, io.SeekCurrent, 11)
+	s.expectSeek(f, 0, io.SeekStart, 0)
+	s.expectRead(f, "hello")
+	s.expectSeek(f, -5, io.SeekEnd, 6)
+	s.expectRead(f, "world")
+	n, err := f.Read(make([]byte, 4))
+	if n != 0 || err != io.EOF {
+		s.t.Fatalf("Read at end returned %v, %v, expected io.EOF", n, err)
+	}
+	_, err = f.Seek(-1, io.SeekStart)
+	s.expectFailure(err, "Seek to a negative offset")
+	s.expectSeek(f, 13, io.SeekStart, 13)
+	f.WriteString("!")
+	s.expectContents("foo.txt", "hello world\x00\x00!")
+	s.expectSeek(f, 0, io.SeekStart, 0)
+	f.WriteString("J")
+	s.expectContents("foo.txt", "Jello world\x00\x00!")
+}
+
+func testReadAtWriteAt(s *suite) {
+	f := s.open("foo.txt", os.O_RDWR|os.O_CREATE)
+	f.WriteString("hello world")
+	if n, err := f.WriteAt([]byte("W"), 6); err != nil || n != 1 {
+		s.t.Fatalf("WriteAt returned %v, %v", n, err)
+	}
+	s.expectSeek(f, 0, io.SeekCurrent, 11)
+	b := make([]byte, 5)
+	if n, err := f.ReadAt(b, 6); err != nil || string(b[:n]) != "World" {
+		s.t.Fatalf("ReadAt returned %q, %v", b[:n], err)
+	}
+	if n, err := f.ReadAt(b, 8); err != io.EOF || string(b[:n]) != "rld" {
+		s.t.Fatalf("Short ReadAt returned %q, %v, expected io.EOF", b[:n], err)
+	}
+	s.expectSeek(f, 0, io.SeekCurrent, 11)
+	_, err := f.ReadAt(b, -1)
+	s.expectFailure(err, "ReadAt at a negative offset")
+	f.WriteAt([]byte("!"), 13)
+	s.expectContents("foo.txt", "hello World\x00\x00!")
+	f = s.open("foo.txt", os.O_WRONLY|os.O_APPEND)
+	_, err = f.WriteAt([]byte("x"), 0)
+	s.expectFailure(err, "WriteAt on a file opened with O_APPEND")
+}
+
+func testReaddir(s *suite) {
+	expected := []string{"a", "b", "c", "d", "e"}
+	for _, name := range expected {
+		s.create(name, "")
+	}
+	f := s.open(".", os.O_RDONLY)
+	var names []string
+	for i := 0; i < 3; i++ {
+		fi, err := f.Readdir(2)
+		s.check(err)
+		for _, info := range fi {
+			names = append(names, info.Name())
+		}
+	}
+	fi, err := f.Readdir(2)
+	if len(fi) != 0 || err != io.EOF {
+		s.t.Fatalf("Readdir past the end returned %v, %v, expected io.EOF", fi, err)
+	}
+	fi, err = f.Readdir(-1)
+	if len(fi) != 0 || err != nil {
+		s.t.Fatalf("Readdir(-1) past the end returned %v, %v", fi, err)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		s.t.Fatalf("Readdir returned %v, expected %v", names, expected)
+	}
+
+	s.expectSeek(f, 0, io.SeekStart, 0)
+	names, err = f.Readdirnames(-1)
+	s.check(err)
+	sort.Strings(names)
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		s.t.Fatalf("Readdirnames after rewinding returned %v", names)
+	}
+
+	f = s.open(".", os.O_RDONLY)
+	entries, err := f.ReadDir(-1)
+	s.check(err)
+	if len(entries) != len(expected) {
+		s.t.Fatalf("ReadDir returned %v entries, expected %v", len(entries), len(expected))
+	}
+	_, err = f.ReadDir(1)
+	s.expectError(io.EOF, err)
+
+	f = s.open("a", os.O_RDONLY)
+	_, err = f.Readdir(-1)
+	s.expectError(syscall.ENOTDIR, err)
+}
+
+func testFileStat(s *suite) {
+	s.create("foo.txt", "hello")
+	f := s.open("foo.txt", os.O_RDWR)
+	fi, err := f.Stat()
+	s.check(err)
+	if fi.Name() != "foo.txt" || fi.Size() != 5 || fi.IsDir() {
+		s.t.Fatalf("Stat returned name %v size %v mode %v", fi.Name(), fi.Size(), fi.Mode())
+	}
+	f.WriteString("hello world")
+	if fi, _ = f.Stat(); fi.Size() != 11 {
+		s.t.Fatalf("Stat after write returned size %v", fi.Size())
+	}
+	s.check(f.Sync())
+	if fi = s.stat("."); !fi.IsDir() {
+		s.t.Fatalf("Stat of the test directory returned mode %v", fi.Mode())
+	}
+}
+
+func testClosed(s *suite) {
+	s.create("foo.txt", "hello")
+	f, err := s.fsys.OpenFile(s.path("foo.txt"), os.O_RDWR, 0)
+	s.check(err)
+	s.check(f.Close())
+	s.expectPathError("close", s.path("foo.txt"), os.ErrClosed, f.Close())
+	_, err = f.Read(make([]byte, 1))
+	s.expectPathError("read", s.path("foo.txt"), os.ErrClosed, err)
+	_, err = f.Write([]byte("x"))
+	s.expectPathError("write", s.path("foo.txt"), os.ErrClosed, err)
+	_, err = f.Stat()
+	s.expectError(os.ErrClosed, err)
+	if fd := f.Fd(); fd != ^uintptr(0) {
+		s.t.Fatalf("Fd of a closed file returned %v", fd)
+	}
+}
+
+// Filesystems without real descriptors may refuse SyscallConn with an
+// error matching errors.ErrUnsupported.
+// Filesystems may hide descriptors, as a closed *os.File does, but must
+// then refuse raw access as well.
+func testFdSyscallConn(s *suite) {
+	s.create("foo.txt", "")
+	f := s.open("foo.txt", os.O_RDONLY)
+	fd := f.Fd()
+	conn, err := f.SyscallConn()
+	if fd == ^uintptr(0) {
+		s.expectError(errors.ErrUnsupported, err)
+		return
+	}
+	if other := s.open("foo.txt", os.O_RDONLY); other.Fd() == fd {
+		s.t.Fatalf("Two open files share descriptor %v", fd)
+	}
+	if errors.Is(err, errors.ErrUnsupported) {
+		return
+	}
+	s.check(err)
+	var control uintptr
+	s.check(conn.Control(func(c uintptr) { control = c }))
+	if control != fd {
+		s.t.Fatalf("Control passed descriptor %v, expected %v", control, fd)
+	}
+}
+
+func testDeadline(s *suite) {
+	s.create("foo.txt", "")
+	f := s.open("foo.txt", os.O_RDWR)
+	deadline := time.Now().Add(time.Second)
+	s.expectError(os.ErrNoDeadline, f.SetDeadline(deadline))
+	s.expectError(os.ErrNoDeadline, f.SetReadDeadline(deadline))
+	s.expectError(os.ErrNoDeadline, f.SetWriteDeadline(deadline))
+}
+
+func testReadFromWriteTo(s *suite) {
+	f := s.open("foo.txt", os.O_RDWR|os.O_CREATE)
+	n, err := f.ReadFrom(strings.NewReader("hello world"))
+	if err != nil || n != 11 {
+		s.t.Fatalf("ReadFrom returned %v, %v", n, err)
+	}
+	s.expectContents("foo.txt", "hello world")
+	s.expectSeek(f, 6, io.SeekStart, 6)
+	var sb strings.Builder
+	if n, err = f.WriteTo(&sb); err != nil || n != 5 || sb.String() != "world" {
+		s.t.Fatalf("WriteTo returned %v, %v, %q", n, err, sb.String())
+	}
+	g := s.open("bar.txt", os.O_RDWR|os.O_CREATE)
+	s.expectSeek(f, 0, io.SeekStart, 0)
+	if n, err = io.Copy(g, f); err != nil || n != 11 {
+		s.t.Fatalf("io.Copy returned %v, %v", n, err)
+	}
+	s.expectContents("bar.txt", "hello world")
+}
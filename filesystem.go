@@ -12,25 +12,41 @@
 package fauxfile
 
 import (
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type File interface {
 	Chdir() error
 	Chmod(mode os.FileMode) error
+	Chown(uid int, gid int) error
 	Close() error
+	Fd() uintptr
 	Name() string
 	Read(b []byte) (n int, err error)
 	ReadAt(b []byte, off int64) (n int, err error)
+	ReadDir(n int) ([]fs.DirEntry, error)
+	ReadFrom(r io.Reader) (n int64, err error)
 	Readdir(n int) (fi []os.FileInfo, err error)
 	Readdirnames(n int) (names []string, err error)
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
 	Stat() (fi os.FileInfo, err error)
 	Sync() (err error)
+	SyscallConn() (syscall.RawConn, error)
 	Seek(offset int64, whence int) (ret int64, err error)
 	Truncate(size int64) error
 	Write(b []byte) (n int, err error)
 	WriteAt(b []byte, off int64) (n int, err error)
 	WriteString(s string) (ret int, err error)
+	WriteTo(w io.Writer) (n int64, err error)
 }
 
 type Filesystem interface {
@@ -44,46 +60,286 @@ type Filesystem interface {
 	Open(name string) (file File, err error)
 	OpenFile(name string, flag int, perm os.FileMode) (file File, err error)
 	Stat(name string) (fi os.FileInfo, err error)
+	Lstat(name string) (fi os.FileInfo, err error)
+	Symlink(oldname string, newname string) error
+	Readlink(name string) (string, error)
+	Link(oldname string, newname string) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	Chown(name string, uid int, gid int) error
+	Lchown(name string, uid int, gid int) error
+	Getwd() (dir string, err error)
+	Chmod(name string, mode os.FileMode) error
+	Truncate(name string, size int64) error
+	ReadDir(name string) ([]os.DirEntry, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	MkdirTemp(dir string, pattern string) (string, error)
+	CreateTemp(dir string, pattern string) (file File, err error)
+	TempDir() string
 }
 
-type RealFilesystem struct{}
+// A Filesystem which passes operations through to the operating system.
+// Each RealFilesystem keeps its own working directory: Chdir, on the
+// filesystem or on a File opened from it, never changes the process's
+// working directory, which relative names resolve against until the first
+// Chdir.  The zero value is ready to use.
+type RealFilesystem struct {
+	mu  sync.Mutex
+	cwd string
+}
+
+// Resolves name against the working directory.  Returns the prefix added
+// to name, which is empty if name is absolute or Chdir has not been called.
+func (f *RealFilesystem) resolve(name string) (path string, prefix string) {
+	if name == "" || filepath.IsAbs(name) || filepath.VolumeName(name) != "" || os.IsPathSeparator(name[0]) {
+		return name, ""
+	}
+	f.mu.Lock()
+	cwd := f.cwd
+	f.mu.Unlock()
+	if cwd == "" {
+		return name, ""
+	}
+	if prefix = cwd; !os.IsPathSeparator(cwd[len(cwd)-1]) {
+		prefix += string(filepath.Separator)
+	}
+	return prefix + name, prefix
+}
+
+// Removes prefix from the path in err, so that errors report names the
+// way the caller passed them.
+func unresolve(err error, prefix string) error {
+	if perr, ok := err.(*os.PathError); ok && prefix != "" {
+		return &os.PathError{
+			Op:   perr.Op,
+			Path: strings.TrimPrefix(perr.Path, prefix),
+			Err:  perr.Err,
+		}
+	}
+	return err
+}
+
+// Reports err, an *os.LinkError from the os package, against oldname and
+// newname.
+func relink(err error, oldname string, newname string) error {
+	if lerr, ok := err.(*os.LinkError); ok {
+		return newLinkError(lerr.Op, oldname, newname, lerr.Err)
+	}
+	return err
+}
+
+// Returns the error inside err if it is an *os.PathError.
+func underlying(err error) error {
+	if perr, ok := err.(*os.PathError); ok {
+		return perr.Err
+	}
+	return err
+}
+
+// Makes the directory at path, which must exist, the working directory.
+// The physical path is stored, as getcwd would report it.
+func (f *RealFilesystem) setwd(path string) error {
+	if !filepath.IsAbs(path) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		path = wd + string(filepath.Separator) + path
+	}
+	physical, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return underlying(err)
+	}
+	f.mu.Lock()
+	f.cwd = physical
+	f.mu.Unlock()
+	return nil
+}
 
 func (f *RealFilesystem) Chdir(dir string) error {
-	return os.Chdir(dir)
+	path, _ := f.resolve(dir)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return newPathError("chdir", dir, underlying(err))
+	}
+	if !fi.IsDir() {
+		return newPathError("chdir", dir, syscall.ENOTDIR)
+	}
+	if err = f.setwd(path); err != nil {
+		return newPathError("chdir", dir, err)
+	}
+	return nil
+}
+
+func (f *RealFilesystem) Getwd() (dir string, err error) {
+	f.mu.Lock()
+	cwd := f.cwd
+	f.mu.Unlock()
+	if cwd == "" {
+		return os.Getwd()
+	}
+	return cwd, nil
 }
 
 func (f *RealFilesystem) Mkdir(name string, perm os.FileMode) error {
-	return os.Mkdir(name, perm)
+	path, prefix := f.resolve(name)
+	return unresolve(os.Mkdir(path, perm), prefix)
 }
 
 func (f *RealFilesystem) MkdirAll(path string, perm os.FileMode) error {
-	return os.MkdirAll(path, perm)
+	fullpath, prefix := f.resolve(path)
+	return unresolve(os.MkdirAll(fullpath, perm), prefix)
 }
 
 func (f *RealFilesystem) Remove(name string) error {
-	return os.Remove(name)
+	path, prefix := f.resolve(name)
+	return unresolve(os.Remove(path), prefix)
 }
 
 func (f *RealFilesystem) RemoveAll(path string) error {
-	return os.RemoveAll(path)
+	fullpath, prefix := f.resolve(path)
+	return unresolve(os.RemoveAll(fullpath), prefix)
 }
 
 func (f *RealFilesystem) Rename(oldname string, newname string) error {
-	return os.Rename(oldname, newname)
+	oldpath, _ := f.resolve(oldname)
+	newpath, _ := f.resolve(newname)
+	return relink(os.Rename(oldpath, newpath), oldname, newname)
 }
 
 func (f *RealFilesystem) Create(name string) (file File, err error) {
-	return os.Create(name)
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 }
 
 func (f *RealFilesystem) Open(name string) (file File, err error) {
-	return os.Open(name)
+	return f.OpenFile(name, os.O_RDONLY, 0)
 }
 
 func (f *RealFilesystem) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
-	return os.OpenFile(name, flag, perm)
+	path, prefix := f.resolve(name)
+	osFile, err := os.OpenFile(path, flag, perm)
+	if err != nil {
+		return nil, unresolve(err, prefix)
+	}
+	return &realFile{File: osFile, name: name, filesystem: f}, nil
 }
 
 func (f *RealFilesystem) Stat(name string) (fi os.FileInfo, err error) {
-	return os.Stat(name)
+	path, prefix := f.resolve(name)
+	fi, err = os.Stat(path)
+	return fi, unresolve(err, prefix)
+}
+
+func (f *RealFilesystem) Lstat(name string) (fi os.FileInfo, err error) {
+	path, prefix := f.resolve(name)
+	fi, err = os.Lstat(path)
+	return fi, unresolve(err, prefix)
+}
+
+// Creates newname as a symbolic link to oldname.  A relative oldname is
+// stored as is, and so is relative to the directory containing newname.
+func (f *RealFilesystem) Symlink(oldname string, newname string) error {
+	newpath, _ := f.resolve(newname)
+	return relink(os.Symlink(oldname, newpath), oldname, newname)
+}
+
+func (f *RealFilesystem) Readlink(name string) (string, error) {
+	path, prefix := f.resolve(name)
+	target, err := os.Readlink(path)
+	return target, unresolve(err, prefix)
+}
+
+func (f *RealFilesystem) Link(oldname string, newname string) error {
+	oldpath, _ := f.resolve(oldname)
+	newpath, _ := f.resolve(newname)
+	return relink(os.Link(oldpath, newpath), oldname, newname)
+}
+
+func (f *RealFilesystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	path, prefix := f.resolve(name)
+	return unresolve(os.Chtimes(path, atime, mtime), prefix)
+}
+
+func (f *RealFilesystem) Chown(name string, uid int, gid int) error {
+	path, prefix := f.resolve(name)
+	return unresolve(os.Chown(path, uid, gid), prefix)
+}
+
+func (f *RealFilesystem) Lchown(name string, uid int, gid int) error {
+	path, prefix := f.resolve(name)
+	return unresolve(os.Lchown(path, uid, gid), prefix)
+}
+
+func (f *RealFilesystem) Chmod(name string, mode os.FileMode) error {
+	path, prefix := f.resolve(name)
+	return unresolve(os.Chmod(path, mode), prefix)
+}
+
+func (f *RealFilesystem) Truncate(name string, size int64) error {
+	path, prefix := f.resolve(name)
+	return unresolve(os.Truncate(path, size), prefix)
+}
+
+func (f *RealFilesystem) ReadDir(name string) ([]os.DirEntry, error) {
+	path, prefix := f.resolve(name)
+	entries, err := os.ReadDir(path)
+	return entries, unresolve(err, prefix)
+}
+
+func (f *RealFilesystem) ReadFile(name string) ([]byte, error) {
+	path, prefix := f.resolve(name)
+	data, err := os.ReadFile(path)
+	return data, unresolve(err, prefix)
+}
+
+func (f *RealFilesystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	path, prefix := f.resolve(name)
+	return unresolve(os.WriteFile(path, data, perm), prefix)
+}
+
+func (f *RealFilesystem) MkdirTemp(dir string, pattern string) (string, error) {
+	path, prefix := f.resolve(dir)
+	name, err := os.MkdirTemp(path, pattern)
+	return strings.TrimPrefix(name, prefix), unresolve(err, prefix)
+}
+
+func (f *RealFilesystem) CreateTemp(dir string, pattern string) (file File, err error) {
+	path, prefix := f.resolve(dir)
+	osFile, err := os.CreateTemp(path, pattern)
+	if err != nil {
+		return nil, unresolve(err, prefix)
+	}
+	name := strings.TrimPrefix(osFile.Name(), prefix)
+	return &realFile{File: osFile, name: name, filesystem: f}, nil
+}
+
+func (f *RealFilesystem) TempDir() string {
+	return os.TempDir()
+}
+
+// An *os.File opened through a RealFilesystem.  Chdir changes the
+// filesystem's working directory rather than the process's, and Name
+// reports the name the file was opened with.
+type realFile struct {
+	*os.File
+	name       string
+	filesystem *RealFilesystem
+}
+
+func (f *realFile) Chdir() error {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return newPathError("chdir", f.name, underlying(err))
+	}
+	if !fi.IsDir() {
+		return newPathError("chdir", f.name, syscall.ENOTDIR)
+	}
+	if err = f.filesystem.setwd(f.File.Name()); err != nil {
+		return newPathError("chdir", f.name, err)
+	}
+	return nil
+}
+
+func (f *realFile) Name() string {
+	return f.name
 }
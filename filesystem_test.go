@@ -0,0 +1,155 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/kurrik/fauxfile"
+	"github.com/kurrik/fauxfile/fauxfiletest"
+)
+
+func TestRealFilesystemConformance(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The conformance tests expect POSIX semantics")
+	}
+	fauxfiletest.TestFilesystem(t, func(t *testing.T) (fauxfile.Filesystem, string) {
+		return &fauxfile.RealFilesystem{}, t.TempDir()
+	})
+}
+
+func TestRootedFilesystemConformance(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The conformance tests expect POSIX semantics")
+	}
+	fauxfiletest.TestFilesystem(t, func(t *testing.T) (fauxfile.Filesystem, string) {
+		f, err := fauxfile.NewRootedFilesystem(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewRootedFilesystem returned error: %v", err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f, "/"
+	})
+}
+
+func TestMockFilesystemConformance(t *testing.T) {
+	fauxfiletest.TestFilesystem(t, func(t *testing.T) (fauxfile.Filesystem, string) {
+		mf := fauxfile.NewMockFilesystem()
+		if err := mf.MkdirAll("/home/test", 0755); err != nil {
+			t.Fatalf("MkdirAll returned error: %v", err)
+		}
+		return mf, "/home/test"
+	})
+}
+
+func TestSubConformance(t *testing.T) {
+	fauxfiletest.TestFilesystem(t, func(t *testing.T) (fauxfile.Filesystem, string) {
+		mf := fauxfile.NewMockFilesystem()
+		if err := mf.MkdirAll("/home/test", 0755); err != nil {
+			t.Fatalf("MkdirAll returned error: %v", err)
+		}
+		return fauxfile.Sub(mf, "/home"), "/test"
+	})
+}
+
+func TestOverlayFilesystemConformance(t *testing.T) {
+	fauxfiletest.TestFilesystem(t, func(t *testing.T) (fauxfile.Filesystem, string) {
+		lower := fauxfile.NewMockFilesystem()
+		if err := lower.MkdirAll("/home/test", 0755); err != nil {
+			t.Fatalf("MkdirAll returned error: %v", err)
+		}
+		return fauxfile.NewOverlayFilesystem(lower, fauxfile.NewMockFilesystem()), "/home/test"
+	})
+}
+
+func TestOverlayRealFilesystemConformance(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The overlay uses slash-separated paths")
+	}
+	fauxfiletest.TestFilesystem(t, func(t *testing.T) (fauxfile.Filesystem, string) {
+		lower := &fauxfile.RealFilesystem{}
+		return fauxfile.NewOverlayFilesystem(lower, fauxfile.NewMockFilesystem()), t.TempDir()
+	})
+}
+
+func TestRealFilesystemChdir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Creating symbolic links needs extra privileges on Windows")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd returned error: %v", err)
+	}
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("EvalSymlinks returned error: %v", err)
+	}
+	os.MkdirAll(filepath.Join(dir, "a", "b"), 0755)
+	os.WriteFile(filepath.Join(dir, "a", "b", "foo.txt"), []byte("foo"), 0644)
+	os.Symlink(filepath.Join("a", "b"), filepath.Join(dir, "link"))
+
+	fs1 := &fauxfile.RealFilesystem{}
+	fs2 := &fauxfile.RealFilesystem{}
+	if err = fs1.Chdir(filepath.Join(dir, "a")); err != nil {
+		t.Fatalf("Chdir returned error: %v", err)
+	}
+	if cwd, _ := os.Getwd(); cwd != wd {
+		t.Fatalf("Chdir changed the process working directory to %v", cwd)
+	}
+	if cwd, _ := fs2.Getwd(); cwd != wd {
+		t.Fatalf("Chdir changed another filesystem's working directory to %v", cwd)
+	}
+	if data, err := fs1.ReadFile(filepath.Join("b", "foo.txt")); err != nil || string(data) != "foo" {
+		t.Fatalf("Relative ReadFile returned %q, %v", data, err)
+	}
+	_, err = fs1.Open("missing.txt")
+	if perr, ok := err.(*os.PathError); !ok || perr.Path != "missing.txt" {
+		t.Fatalf("Expected error to report the relative name, got %v", err)
+	}
+
+	if err = fs1.Chdir(filepath.Join("..", "link")); err != nil {
+		t.Fatalf("Chdir through a symlink returned error: %v", err)
+	}
+	if cwd, _ := fs1.Getwd(); cwd != filepath.Join(dir, "a", "b") {
+		t.Fatalf("Getwd returned %v, expected the physical path", cwd)
+	}
+	if err = fs1.Chdir(".."); err != nil {
+		t.Fatalf("Chdir returned error: %v", err)
+	}
+	f, err := fs1.Open("b")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer f.Close()
+	if f.Name() != "b" {
+		t.Fatalf("Name returned %v, expected b", f.Name())
+	}
+	if err = f.Chdir(); err != nil {
+		t.Fatalf("File.Chdir returned error: %v", err)
+	}
+	if cwd, _ := os.Getwd(); cwd != wd {
+		t.Fatalf("File.Chdir changed the process working directory to %v", cwd)
+	}
+	if _, err = fs1.Stat("foo.txt"); err != nil {
+		t.Fatalf("Stat after File.Chdir returned error: %v", err)
+	}
+	name, err := fs1.MkdirTemp(".", "tmp")
+	if err != nil || filepath.Dir(name) != "." {
+		t.Fatalf("MkdirTemp returned %v, %v, expected a relative name", name, err)
+	}
+}
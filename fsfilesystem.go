@@ -0,0 +1,456 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A read-only Filesystem backed by an fs.FS such as an embed.FS, an
+// fstest.MapFS or a *zip.Reader.  The root of the fs.FS appears as "/",
+// and relative names are resolved against a working directory set with
+// Chdir.  Methods which would modify the filesystem fail with EROFS.
+type FSFilesystem struct {
+	mu   sync.Mutex
+	fsys fs.FS
+	cwd  string
+	fd   uintptr
+}
+
+func NewFSFilesystem(fsys fs.FS) *FSFilesystem {
+	return &FSFilesystem{
+		fsys: fsys,
+		cwd:  ".",
+	}
+}
+
+// Translates name into a path within the fs.FS.  Paths are cleaned
+// lexically, so ".." never leaves the root.
+func (f *FSFilesystem) fspath(name string) string {
+	if !strings.HasPrefix(name, "/") {
+		f.mu.Lock()
+		name = "/" + f.cwd + "/" + name
+		f.mu.Unlock()
+	}
+	if name = path.Clean(name)[1:]; name == "" {
+		return "."
+	}
+	return name
+}
+
+func (f *FSFilesystem) Chdir(dir string) error {
+	fi, err := f.Stat(dir)
+	if err != nil {
+		return fsPathError("chdir", dir, err)
+	}
+	if !fi.IsDir() {
+		return newPathError("chdir", dir, syscall.ENOTDIR)
+	}
+	p := f.fspath(dir)
+	f.mu.Lock()
+	f.cwd = p
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *FSFilesystem) Getwd() (dir string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cwd == "." {
+		return "/", nil
+	}
+	return "/" + f.cwd, nil
+}
+
+func (f *FSFilesystem) Mkdir(name string, perm os.FileMode) error {
+	return newPathError("mkdir", name, errReadOnly)
+}
+
+func (f *FSFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	if fi, err := f.Stat(path); err == nil && fi.IsDir() {
+		return nil
+	}
+	return newPathError("mkdir", path, errReadOnly)
+}
+
+func (f *FSFilesystem) Remove(name string) error {
+	return newPathError("remove", name, errReadOnly)
+}
+
+// Does nothing if path does not exist, like os.RemoveAll.
+func (f *FSFilesystem) RemoveAll(path string) error {
+	if _, err := f.Lstat(path); os.IsNotExist(err) {
+		return nil
+	}
+	return newPathError("unlinkat", path, errReadOnly)
+}
+
+func (f *FSFilesystem) Rename(oldname string, newname string) error {
+	return newLinkError("rename", oldname, newname, errReadOnly)
+}
+
+func (f *FSFilesystem) Create(name string) (file File, err error) {
+	return nil, newPathError("open", name, errReadOnly)
+}
+
+func (f *FSFilesystem) Open(name string) (file File, err error) {
+	if name == "" {
+		return nil, newPathError("open", name, syscall.ENOENT)
+	}
+	p := f.fspath(name)
+	fsf, err := f.fsys.Open(p)
+	if err != nil {
+		return nil, fsPathError("open", name, err)
+	}
+	f.mu.Lock()
+	f.fd++
+	fd := mockFdBase + f.fd
+	f.mu.Unlock()
+	return &fsFile{
+		filesystem: f,
+		file:       fsf,
+		name:       name,
+		path:       p,
+		fd:         fd,
+	}, nil
+}
+
+// Opens the named file for reading.  Flags which would create, truncate or
+// write to the file fail with EROFS.
+func (f *FSFilesystem) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	if isWritable(flag) || flag&(os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, newPathError("open", name, errReadOnly)
+	}
+	return f.Open(name)
+}
+
+func (f *FSFilesystem) Stat(name string) (fi os.FileInfo, err error) {
+	if name == "" {
+		return nil, newPathError("stat", name, syscall.ENOENT)
+	}
+	if fi, err = fs.Stat(f.fsys, f.fspath(name)); err != nil {
+		return nil, fsPathError("stat", name, err)
+	}
+	return fi, nil
+}
+
+// Returns information about the named file without following a symbolic
+// link, if the fs.FS implements fs.ReadLinkFS.  Otherwise it is the same
+// as Stat.
+func (f *FSFilesystem) Lstat(name string) (fi os.FileInfo, err error) {
+	if name == "" {
+		return nil, newPathError("lstat", name, syscall.ENOENT)
+	}
+	if fi, err = fs.Lstat(f.fsys, f.fspath(name)); err != nil {
+		return nil, fsPathError("lstat", name, err)
+	}
+	return fi, nil
+}
+
+func (f *FSFilesystem) Symlink(oldname string, newname string) error {
+	return newLinkError("symlink", oldname, newname, errReadOnly)
+}
+
+func (f *FSFilesystem) Readlink(name string) (string, error) {
+	if name == "" {
+		return "", newPathError("readlink", name, syscall.ENOENT)
+	}
+	target, err := fs.ReadLink(f.fsys, f.fspath(name))
+	if err != nil {
+		return "", fsPathError("readlink", name, err)
+	}
+	return target, nil
+}
+
+func (f *FSFilesystem) Link(oldname string, newname string) error {
+	return newLinkError("link", oldname, newname, errReadOnly)
+}
+
+func (f *FSFilesystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return newPathError("chtimes", name, errReadOnly)
+}
+
+func (f *FSFilesystem) Chown(name string, uid int, gid int) error {
+	return newPathError("chown", name, errReadOnly)
+}
+
+func (f *FSFilesystem) Lchown(name string, uid int, gid int) error {
+	return newPathError("lchown", name, errReadOnly)
+}
+
+func (f *FSFilesystem) Chmod(name string, mode os.FileMode) error {
+	return newPathError("chmod", name, errReadOnly)
+}
+
+func (f *FSFilesystem) Truncate(name string, size int64) error {
+	return newPathError("truncate", name, errReadOnly)
+}
+
+func (f *FSFilesystem) ReadDir(name string) ([]os.DirEntry, error) {
+	if name == "" {
+		return nil, newPathError("open", name, syscall.ENOENT)
+	}
+	entries, err := fs.ReadDir(f.fsys, f.fspath(name))
+	if err != nil {
+		return nil, fsPathError("open", name, err)
+	}
+	return entries, nil
+}
+
+func (f *FSFilesystem) ReadFile(name string) ([]byte, error) {
+	if name == "" {
+		return nil, newPathError("open", name, syscall.ENOENT)
+	}
+	data, err := fs.ReadFile(f.fsys, f.fspath(name))
+	if err != nil {
+		return nil, fsPathError("open", name, err)
+	}
+	return data, nil
+}
+
+func (f *FSFilesystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return newPathError("open", name, errReadOnly)
+}
+
+func (f *FSFilesystem) MkdirTemp(dir string, pattern string) (string, error) {
+	return "", newPathError("mkdirtemp", dir, errReadOnly)
+}
+
+func (f *FSFilesystem) CreateTemp(dir string, pattern string) (file File, err error) {
+	return nil, newPathError("createtemp", dir, errReadOnly)
+}
+
+func (f *FSFilesystem) TempDir() string {
+	return "/tmp"
+}
+
+// Reports err from the fs.FS as an error from op on name, as the os
+// package would.
+func fsPathError(op string, name string, err error) error {
+	var perr *fs.PathError
+	if errors.As(err, &perr) {
+		return newPathError(op, name, perr.Err)
+	}
+	return err
+}
+
+// A File opened from an FSFilesystem.
+type fsFile struct {
+	mu         sync.Mutex
+	filesystem *FSFilesystem
+	file       fs.File
+	name       string
+	path       string
+	fd         uintptr
+	closed     bool
+}
+
+func (f *fsFile) pathError(op string, err error) error {
+	return newPathError(op, f.name, err)
+}
+
+// Returns ErrFileClosed as an error from op if the file has been closed.
+func (f *fsFile) checkClosed(op string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return f.pathError(op, ErrFileClosed)
+	}
+	return nil
+}
+
+func (f *fsFile) Chdir() error {
+	if err := f.checkClosed("chdir"); err != nil {
+		return err
+	}
+	fi, err := f.file.Stat()
+	if err != nil {
+		return fsPathError("chdir", f.name, err)
+	}
+	if !fi.IsDir() {
+		return f.pathError("chdir", syscall.ENOTDIR)
+	}
+	f.filesystem.mu.Lock()
+	f.filesystem.cwd = f.path
+	f.filesystem.mu.Unlock()
+	return nil
+}
+
+func (f *fsFile) Chmod(mode os.FileMode) error {
+	return f.pathError("chmod", errReadOnly)
+}
+
+func (f *fsFile) Chown(uid int, gid int) error {
+	return f.pathError("chown", errReadOnly)
+}
+
+func (f *fsFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return f.pathError("close", ErrFileClosed)
+	}
+	f.closed = true
+	return f.file.Close()
+}
+
+// Returns a fake descriptor number, numbered like those of a MockFile so
+// that it cannot collide with a real one, or ^uintptr(0) once the file is
+// closed.
+func (f *fsFile) Fd() uintptr {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ^uintptr(0)
+	}
+	return f.fd
+}
+
+func (f *fsFile) Name() string {
+	return f.name
+}
+
+func (f *fsFile) Read(b []byte) (n int, err error) {
+	if err = f.checkClosed("read"); err != nil {
+		return 0, err
+	}
+	return f.file.Read(b)
+}
+
+// Fails with ENOTSUP if the underlying file does not implement io.ReaderAt.
+func (f *fsFile) ReadAt(b []byte, off int64) (n int, err error) {
+	if err = f.checkClosed("readat"); err != nil {
+		return 0, err
+	}
+	r, ok := f.file.(io.ReaderAt)
+	if !ok {
+		return 0, f.pathError("readat", errNotSupported)
+	}
+	return r.ReadAt(b, off)
+}
+
+func (f *fsFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if err := f.checkClosed("readdir"); err != nil {
+		return nil, err
+	}
+	dir, ok := f.file.(fs.ReadDirFile)
+	if !ok {
+		return nil, f.pathError("readdirent", syscall.ENOTDIR)
+	}
+	return dir.ReadDir(n)
+}
+
+func (f *fsFile) ReadFrom(r io.Reader) (n int64, err error) {
+	return 0, f.pathError("write", errReadOnly)
+}
+
+func (f *fsFile) Readdir(n int) (fi []os.FileInfo, err error) {
+	entries, err := f.ReadDir(n)
+	fi = make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			return fi, infoErr
+		}
+		fi = append(fi, info)
+	}
+	return fi, err
+}
+
+func (f *fsFile) Readdirnames(n int) (names []string, err error) {
+	entries, err := f.ReadDir(n)
+	names = make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names, err
+}
+
+func (f *fsFile) SetDeadline(t time.Time) error {
+	if err := f.checkClosed("SetDeadline"); err != nil {
+		return err
+	}
+	return os.ErrNoDeadline
+}
+
+func (f *fsFile) SetReadDeadline(t time.Time) error {
+	if err := f.checkClosed("SetReadDeadline"); err != nil {
+		return err
+	}
+	return os.ErrNoDeadline
+}
+
+func (f *fsFile) SetWriteDeadline(t time.Time) error {
+	if err := f.checkClosed("SetWriteDeadline"); err != nil {
+		return err
+	}
+	return os.ErrNoDeadline
+}
+
+func (f *fsFile) Stat() (fi os.FileInfo, err error) {
+	if err = f.checkClosed("stat"); err != nil {
+		return nil, err
+	}
+	return f.file.Stat()
+}
+
+func (f *fsFile) Sync() (err error) {
+	return f.checkClosed("sync")
+}
+
+// Fails with ENOTSUP, as the file has no real descriptor.
+func (f *fsFile) SyscallConn() (syscall.RawConn, error) {
+	return nil, f.pathError("syscallconn", errNotSupported)
+}
+
+// Fails with ENOTSUP if the underlying file does not implement io.Seeker.
+func (f *fsFile) Seek(offset int64, whence int) (ret int64, err error) {
+	if err = f.checkClosed("seek"); err != nil {
+		return 0, err
+	}
+	s, ok := f.file.(io.Seeker)
+	if !ok {
+		return 0, f.pathError("seek", errNotSupported)
+	}
+	return s.Seek(offset, whence)
+}
+
+func (f *fsFile) Truncate(size int64) error {
+	return f.pathError("truncate", errReadOnly)
+}
+
+func (f *fsFile) Write(b []byte) (n int, err error) {
+	return 0, f.pathError("write", errReadOnly)
+}
+
+func (f *fsFile) WriteAt(b []byte, off int64) (n int, err error) {
+	return 0, f.pathError("write", errReadOnly)
+}
+
+func (f *fsFile) WriteString(s string) (ret int, err error) {
+	return 0, f.pathError("write", errReadOnly)
+}
+
+func (f *fsFile) WriteTo(w io.Writer) (n int64, err error) {
+	return io.Copy(w, struct{ io.Reader }{f})
+}
@@ -0,0 +1,143 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestFSFilesystem() *FSFilesystem {
+	return NewFSFilesystem(fstest.MapFS{
+		"templates/base.tmpl":  {Data: []byte("{{.}}")},
+		"templates/page.tmpl":  {Data: []byte("page")},
+		"fixtures/users.json":  {Data: []byte("[]"), Mode: 0600},
+		"fixtures/empty":       {Mode: fs.ModeDir | 0755},
+		"fixtures/link":        {Data: []byte("users.json"), Mode: fs.ModeSymlink},
+		"fixtures/nested/a.md": {Data: []byte("# a")},
+	})
+}
+
+func TestFSFilesystemRead(t *testing.T) {
+	f := newTestFSFilesystem()
+	file, err := f.Open("/templates/base.tmpl")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil || string(data) != "{{.}}" {
+		t.Fatalf("Read returned %q, %v", data, err)
+	}
+	if file.Name() != "/templates/base.tmpl" {
+		t.Fatalf("Name returned %v", file.Name())
+	}
+	b := make([]byte, 2)
+	if n, err := file.ReadAt(b, 2); err != nil || string(b[:n]) != ".}" {
+		t.Fatalf("ReadAt returned %q, %v", b[:n], err)
+	}
+	file.Close()
+	ExpectPathError(t, "close", "/templates/base.tmpl", os.ErrClosed, file.Close())
+	fi, err := f.Stat("fixtures/users.json")
+	if err != nil || fi.Size() != 2 || fi.Mode() != 0600 {
+		t.Fatalf("Stat returned %v, %v", fi, err)
+	}
+	_, err = f.Stat("missing")
+	ExpectPathError(t, "stat", "missing", fs.ErrNotExist, err)
+	if target, err := f.Readlink("fixtures/link"); err != nil || target != "users.json" {
+		t.Fatalf("Readlink returned %v, %v", target, err)
+	}
+	if fi, err = f.Lstat("fixtures/link"); err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+		t.Fatalf("Lstat returned %v, %v", fi, err)
+	}
+	_, err = f.Stat("")
+	ExpectPathError(t, "stat", "", fs.ErrNotExist, err)
+	_, err = f.Lstat("")
+	ExpectPathError(t, "lstat", "", fs.ErrNotExist, err)
+	_, err = f.ReadFile("")
+	ExpectPathError(t, "open", "", fs.ErrNotExist, err)
+}
+
+func TestFSFilesystemReaddir(t *testing.T) {
+	f := newTestFSFilesystem()
+	dir, err := f.Open("fixtures")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil || len(names) != 4 {
+		t.Fatalf("Readdirnames returned %v, %v", names, err)
+	}
+	entries, err := f.ReadDir("/fixtures")
+	if err != nil || len(entries) != 4 || entries[0].Name() != "empty" {
+		t.Fatalf("ReadDir returned %v, %v", entries, err)
+	}
+	file, _ := f.Open("fixtures/users.json")
+	defer file.Close()
+	_, err = file.Readdir(-1)
+	ExpectPathError(t, "readdirent", "fixtures/users.json", syscall.ENOTDIR, err)
+}
+
+func TestFSFilesystemChdir(t *testing.T) {
+	f := newTestFSFilesystem()
+	if err := f.Chdir("fixtures/nested"); err != nil {
+		t.Fatalf("Chdir returned error: %v", err)
+	}
+	if dir, _ := f.Getwd(); dir != "/fixtures/nested" {
+		t.Fatalf("Getwd returned %v", dir)
+	}
+	if data, err := f.ReadFile("a.md"); err != nil || string(data) != "# a" {
+		t.Fatalf("ReadFile returned %q, %v", data, err)
+	}
+	if _, err := f.Stat("../../../templates/page.tmpl"); err != nil {
+		t.Fatalf("Stat above the root should stay within it: %v", err)
+	}
+	ExpectPathError(t, "chdir", "a.md", syscall.ENOTDIR, f.Chdir("a.md"))
+	dir, _ := f.Open("/templates")
+	defer dir.Close()
+	if err := dir.Chdir(); err != nil {
+		t.Fatalf("File.Chdir returned error: %v", err)
+	}
+	if wd, _ := f.Getwd(); wd != "/templates" {
+		t.Fatalf("Getwd returned %v", wd)
+	}
+}
+
+func TestFSFilesystemReadOnly(t *testing.T) {
+	f := newTestFSFilesystem()
+	_, err := f.Create("new.txt")
+	ExpectPathError(t, "open", "new.txt", errReadOnly, err)
+	_, err = f.OpenFile("templates/base.tmpl", os.O_RDWR, 0)
+	ExpectPathError(t, "open", "templates/base.tmpl", errReadOnly, err)
+	ExpectPathError(t, "mkdir", "dir", errReadOnly, f.Mkdir("dir", 0755))
+	ExpectPathError(t, "remove", "templates/base.tmpl", errReadOnly, f.Remove("templates/base.tmpl"))
+	ExpectError(t, errReadOnly, f.Rename("templates", "views"))
+	ExpectError(t, errReadOnly, f.WriteFile("new.txt", nil, 0644))
+	if err = f.MkdirAll("fixtures/nested", 0755); err != nil {
+		t.Fatalf("MkdirAll of an existing directory should succeed: %v", err)
+	}
+	if err = f.RemoveAll("missing"); err != nil {
+		t.Fatalf("RemoveAll of a missing path should succeed: %v", err)
+	}
+	file, _ := f.Open("templates/base.tmpl")
+	defer file.Close()
+	_, err = file.Write([]byte("x"))
+	ExpectPathError(t, "write", "templates/base.tmpl", errReadOnly, err)
+	ExpectPathError(t, "chmod", "templates/base.tmpl", errReadOnly, file.Chmod(0644))
+}
@@ -0,0 +1,123 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+)
+
+// Returns an fs.FS for the tree rooted at dir within fsys, so that a
+// Filesystem can be used with fs.WalkDir, fs.Glob, http.FS and the like.
+// The result also implements fs.StatFS, fs.ReadDirFS, fs.ReadFileFS,
+// fs.GlobFS and fs.SubFS.  As with os.DirFS, symbolic links are followed
+// even when they lead outside dir.
+func DirFS(fsys Filesystem, dir string) fs.FS {
+	return dirFS{fsys: fsys, dir: dir}
+}
+
+type dirFS struct {
+	fsys Filesystem
+	dir  string
+}
+
+// Returns the path of name within the underlying Filesystem.
+func (d dirFS) join(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	switch {
+	case name == ".":
+		return d.dir, nil
+	case d.dir == "":
+		return name, nil
+	case strings.HasSuffix(d.dir, "/"):
+		return d.dir + name, nil
+	}
+	return d.dir + "/" + name, nil
+}
+
+// Replaces the path in a *fs.PathError with name, so that errors refer to
+// the name the caller used rather than to a translated path.
+func withPath(err error, name string) error {
+	var perr *fs.PathError
+	if errors.As(err, &perr) {
+		return &fs.PathError{Op: perr.Op, Path: name, Err: perr.Err}
+	}
+	return err
+}
+
+func (d dirFS) Open(name string) (fs.File, error) {
+	fullname, err := d.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := d.fsys.Open(fullname)
+	if err != nil {
+		return nil, withPath(err, name)
+	}
+	return f, nil
+}
+
+func (d dirFS) Stat(name string) (fs.FileInfo, error) {
+	fullname, err := d.join("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := d.fsys.Stat(fullname)
+	if err != nil {
+		return nil, withPath(err, name)
+	}
+	return fi, nil
+}
+
+func (d dirFS) ReadFile(name string) ([]byte, error) {
+	fullname, err := d.join("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.fsys.ReadFile(fullname)
+	if err != nil {
+		return nil, withPath(err, name)
+	}
+	return data, nil
+}
+
+func (d dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fullname, err := d.join("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := d.fsys.ReadDir(fullname)
+	if err != nil {
+		return nil, withPath(err, name)
+	}
+	return entries, nil
+}
+
+func (d dirFS) Glob(pattern string) ([]string, error) {
+	// Hide Glob so that fs.Glob walks the tree rather than calling back
+	// into this method.
+	return fs.Glob(struct{ fs.ReadDirFS }{d}, pattern)
+}
+
+func (d dirFS) Sub(dir string) (fs.FS, error) {
+	fullname, err := d.join("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	return dirFS{fsys: d.fsys, dir: fullname}, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"io/fs"
+	"path"
+	"testing"
+	"testing/fstest"
+)
+
+func populate(t *testing.T, mf *MockFilesystem) {
+	files := map[string]string{
+		"/srv/index.html":          "<html></html>",
+		"/srv/static/app.js":       "app()",
+		"/srv/static/style.css":    "body {}",
+		"/srv/static/img/logo.png": "\x89PNG",
+		"/srv/templates/base.tmpl": "{{.}}",
+	}
+	for name, contents := range files {
+		if err := mf.MkdirAll(path.Dir(name), 0755); err != nil {
+			t.Fatalf("MkdirAll returned error: %v", err)
+		}
+		if err := mf.WriteFile(name, []byte(contents), 0644); err != nil {
+			t.Fatalf("WriteFile returned error: %v", err)
+		}
+	}
+	mf.Mkdir("/srv/empty", 0755)
+}
+
+func TestDirFS(t *testing.T) {
+	mf := NewMockFilesystem()
+	populate(t, mf)
+	fsys := DirFS(mf, "/srv")
+	err := fstest.TestFS(fsys,
+		"index.html",
+		"static/app.js",
+		"static/style.css",
+		"static/img/logo.png",
+		"templates/base.tmpl",
+		"empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirFSSub(t *testing.T) {
+	mf := NewMockFilesystem()
+	populate(t, mf)
+	sub, err := fs.Sub(DirFS(mf, "/srv"), "static")
+	if err != nil {
+		t.Fatalf("Sub returned error: %v", err)
+	}
+	if err = fstest.TestFS(sub, "app.js", "img/logo.png"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirFSInvalidPath(t *testing.T) {
+	mf := NewMockFilesystem()
+	populate(t, mf)
+	fsys := DirFS(mf, "/srv")
+	for _, name := range []string{"/srv/index.html", "../srv", "static/", "./index.html"} {
+		_, err := fsys.Open(name)
+		ExpectError(t, fs.ErrInvalid, err)
+		_, err = fs.Stat(fsys, name)
+		ExpectError(t, fs.ErrInvalid, err)
+	}
+}
+
+func TestDirFSErrors(t *testing.T) {
+	mf := NewMockFilesystem()
+	populate(t, mf)
+	fsys := DirFS(mf, "/srv")
+	_, err := fs.ReadFile(fsys, "static/missing.js")
+	perr, ok := err.(*fs.PathError)
+	if !ok || perr.Path != "static/missing.js" {
+		t.Fatalf("Expected error for static/missing.js, got %v", err)
+	}
+	ExpectError(t, fs.ErrNotExist, err)
+	matches, err := fs.Glob(fsys, "static/*.js")
+	if err != nil || len(matches) != 1 || matches[0] != "static/app.js" {
+		t.Fatalf("Glob returned %v, %v", matches, err)
+	}
+	_, err = fs.Glob(fsys, "[")
+	ExpectError(t, path.ErrBadPattern, err)
+}
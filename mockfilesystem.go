@@ -18,19 +18,30 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 var (
-	ErrFileClosed = errors.New("File is closed")
-	ErrOutOfRange = errors.New("Out of range")
-	ErrTooLarge   = errors.New("Too large")
+	ErrFileClosed = os.ErrClosed
+	ErrOutOfRange = syscall.EINVAL
+	ErrTooLarge   = errFileTooLarge
+	ErrAppendMode = errors.New("Invalid use of WriteAt on file opened with O_APPEND")
+
+	errPatternHasSeparator = errors.New("pattern contains path separator")
 )
 
+// Returns an *os.PathError with a free-form message.  MockFilesystem itself
+// reports syscall errors so that os.IsNotExist and errors.Is work.
 func GetPathError(path string, message string) error {
 	return &os.PathError{
 		Path: path,
@@ -38,187 +49,937 @@ func GetPathError(path string, message string) error {
 	}
 }
 
+func newPathError(op string, path string, err error) error {
+	return &os.PathError{
+		Op:   op,
+		Path: path,
+		Err:  err,
+	}
+}
+
+func newLinkError(op string, oldname string, newname string, err error) error {
+	return &os.LinkError{
+		Op:  op,
+		Old: oldname,
+		New: newname,
+		Err: err,
+	}
+}
+
+// An in-memory Filesystem.  A MockFilesystem and the files opened from it
+// are safe for concurrent use: each Filesystem and File method is applied
+// atomically, so writes to a file are serialized and every other method
+// observes them either fully or not at all.  Methods on a single File are
+// also serialized, as they share the file's offset.  The os.FileInfo values
+// returned are snapshots which do not change afterwards.
 type MockFilesystem struct {
-	cwd  *MockFileInfo
-	root *MockFileInfo
+	mu    sync.Mutex
+	cwd   *MockFileInfo
+	root  *MockFileInfo
+	clock Clock
+	dev   uint64
+	ino   uint64
+	creds *Credentials
+	umask os.FileMode
+	temp  uint64
+	fd    uintptr
+}
+
+// The device ID most recently assigned to a MockFilesystem.
+var lastMockDevice uint64
+
+// Configures a MockFilesystem created by NewMockFilesystem.
+type MockOption func(mf *MockFilesystem)
+
+// Makes the filesystem take every timestamp it records from clock rather
+// than from time.Now.
+func WithClock(clock Clock) MockOption {
+	return func(mf *MockFilesystem) {
+		mf.clock = clock
+	}
 }
 
-func NewMockFilesystem() *MockFilesystem {
+func NewMockFilesystem(options ...MockOption) *MockFilesystem {
+	mf := &MockFilesystem{
+		clock: realClock{},
+		dev:   atomic.AddUint64(&lastMockDevice, 1),
+		umask: 022,
+	}
+	for _, option := range options {
+		option(mf)
+	}
+	now := mf.clock.Now()
+	uid, gid := mf.owner()
 	root := &MockFileInfo{
+		mockNode: &mockNode{
+			mode:  os.ModeDir | 0755,
+			atime: now,
+			mtime: now,
+			ctime: now,
+			buf:   []byte{},
+			nlink: 2,
+			ino:   mf.nextIno(),
+			uid:   uid,
+			gid:   gid,
+		},
 		name:       "/",
-		filesystem: nil,
-		mode:       os.ModeDir | 0755,
-		modified:   time.Now(),
-		buf:        []byte{},
+		filesystem: mf,
 		parent:     nil,
 		children:   map[string]*MockFileInfo{},
 	}
-	mf := &MockFilesystem{
-		cwd:  root,
-		root: root,
-	}
-	root.filesystem = mf
+	mf.cwd = root
+	mf.root = root
 	return mf
 }
 
-func (mf *MockFilesystem) getpath(path string) string {
-	if filepath.IsAbs(path) {
-		return filepath.Clean(path)
-	}
-	return filepath.Join(mf.cwd.path(), path)
+// Sets the mask cleared from the permission bits of every file and
+// directory the filesystem creates, returning the previous mask.  The
+// default is 022, as for a typical process.
+func (mf *MockFilesystem) Umask(mask os.FileMode) os.FileMode {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	old := mf.umask
+	mf.umask = mask & os.ModePerm
+	return old
 }
 
-func (mf *MockFilesystem) resolve(path string) (*MockFileInfo, error) {
-	path = mf.getpath(path)
-	parts := strings.Split(path, string(filepath.Separator))
-	ptr := mf.root
-	for _, part := range parts {
-		if part == "" {
+// Number of names MkdirTemp and CreateTemp try before giving up, matching
+// the os package.
+const maxTempTries = 10000
+
+// Maximum number of symbolic links followed while resolving a single path,
+// matching Linux.
+const maxSymlinks = 40
+
+func isDots(filename string) bool {
+	return filename == "." || filename == ".."
+}
+
+// Walks path starting from dir, following symbolic links in every element
+// but the last, which is only followed if follow is set.  Errors are
+// returned as bare syscall.Errno values for the caller to wrap with the
+// operation and name.
+func (mf *MockFilesystem) walk(dir *MockFileInfo, path string, follow bool, links *int) (ptr *MockFileInfo, err error) {
+	if path == "" {
+		return nil, syscall.ENOENT
+	}
+	if strings.HasPrefix(path, "/") {
+		dir = mf.root
+	}
+	if strings.HasSuffix(path, "/") {
+		follow = true
+	}
+	parts := []string{}
+	for _, part := range strings.Split(path, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	ptr = dir
+	for i, part := range parts {
+		if !ptr.IsDir() {
+			return nil, syscall.ENOTDIR
+		}
+		if err = mf.access(ptr, permExec); err != nil {
+			return nil, err
+		}
+		if part == "." {
+			continue
+		}
+		if part == ".." {
+			if ptr != mf.root {
+				ptr = ptr.Parent()
+			}
 			continue
 		}
-		if child := ptr.Child(part); child != nil {
-			ptr = ptr.Child(part)
-		} else {
-			return nil, GetPathError(path, "Path does not exist")
+		child := ptr.Child(part)
+		if child == nil {
+			return nil, syscall.ENOENT
+		}
+		if child.isSymlink() && (follow || i < len(parts)-1) {
+			if *links++; *links > maxSymlinks {
+				return nil, errSymlinkLoop
+			}
+			if child, err = mf.walk(ptr, string(child.buf), true, links); err != nil {
+				return nil, err
+			}
 		}
+		ptr = child
+	}
+	if strings.HasSuffix(path, "/") && !ptr.IsDir() {
+		return nil, syscall.ENOTDIR
 	}
 	return ptr, nil
 }
 
+// Walks path starting from dir, returning the directory which would contain
+// the final element of path along with that element's name.  The name is
+// empty if path refers to the root.
+func (mf *MockFilesystem) walkParent(dir *MockFileInfo, path string, links *int) (parent *MockFileInfo, filename string, err error) {
+	if path == "" {
+		return nil, "", syscall.ENOENT
+	}
+	trimmed := strings.TrimRight(path, "/")
+	if trimmed == "" {
+		return mf.root, "", nil
+	}
+	i := strings.LastIndex(trimmed, "/")
+	filename = trimmed[i+1:]
+	parent = dir
+	if i >= 0 {
+		if parent, err = mf.walk(dir, trimmed[:i+1], true, links); err != nil {
+			return nil, "", err
+		}
+	}
+	if !parent.IsDir() {
+		return nil, "", syscall.ENOTDIR
+	}
+	if err = mf.access(parent, permExec); err != nil {
+		return nil, "", err
+	}
+	return parent, filename, nil
+}
+
+// Returns the node at path, following symbolic links.
+func (mf *MockFilesystem) resolve(path string) (*MockFileInfo, error) {
+	links := 0
+	return mf.walk(mf.cwd, path, true, &links)
+}
+
+// Returns the node at path without following a symbolic link in the final
+// element.
+func (mf *MockFilesystem) lresolve(path string) (*MockFileInfo, error) {
+	links := 0
+	return mf.walk(mf.cwd, path, false, &links)
+}
+
+// Returns the directory which would contain path, along with the final
+// element of path.  The caller is permitted to look up the final element.
+func (mf *MockFilesystem) resolveParent(path string) (dir *MockFileInfo, filename string, err error) {
+	links := 0
+	return mf.walkParent(mf.cwd, path, &links)
+}
+
+// Like resolveParent, but if path names a dangling symbolic link then the
+// location the link points to is returned instead, so that it may be
+// created.
+func (mf *MockFilesystem) resolveCreate(path string) (dir *MockFileInfo, filename string, err error) {
+	links := 0
+	dir = mf.cwd
+	for {
+		if strings.HasSuffix(path, "/") {
+			return nil, "", syscall.EISDIR
+		}
+		if dir, filename, err = mf.walkParent(dir, path, &links); err != nil {
+			return nil, "", err
+		}
+		child := dir.Child(filename)
+		if child == nil || !child.isSymlink() {
+			return dir, filename, nil
+		}
+		if links++; links > maxSymlinks {
+			return nil, "", errSymlinkLoop
+		}
+		path = string(child.buf)
+	}
+}
+
+// Like resolveParent, but fails with EEXIST if the final element exists in
+// any form, even as a dangling symbolic link, as open does with O_EXCL.
+func (mf *MockFilesystem) resolveExclusive(path string) (dir *MockFileInfo, filename string, err error) {
+	if strings.HasSuffix(path, "/") {
+		return nil, "", syscall.EISDIR
+	}
+	if dir, filename, err = mf.resolveParent(path); err != nil {
+		return nil, "", err
+	}
+	if dir.Child(filename) != nil {
+		return nil, "", syscall.EEXIST
+	}
+	return dir, filename, nil
+}
+
+// Returns an inode number for a new node.  Numbers are never reused within
+// a filesystem.
+func (mf *MockFilesystem) nextIno() uint64 {
+	mf.ino++
+	return mf.ino
+}
+
+// Fake descriptor numbers start here, above any descriptor the kernel
+// hands out whether read as a signed or unsigned 32-bit value, so that a
+// fake descriptor passed to a system call never names a real file.
+const mockFdBase = 1 << 31
+
+// Returns a descriptor number for a newly opened file.  Numbers are never
+// reused.
+func (mf *MockFilesystem) nextFd() uintptr {
+	mf.fd++
+	return mockFdBase + mf.fd
+}
+
+// Creates a new node with the given mode and contents, linked into dir
+// as filename.
+func (mf *MockFilesystem) create(dir *MockFileInfo, filename string, mode os.FileMode, buf []byte) *MockFileInfo {
+	now := mf.clock.Now()
+	uid, gid := mf.owner()
+	fi := &MockFileInfo{
+		mockNode: &mockNode{
+			mode:  mode,
+			atime: now,
+			mtime: now,
+			ctime: now,
+			buf:   buf,
+			ino:   mf.nextIno(),
+			uid:   uid,
+			gid:   gid,
+		},
+		filesystem: mf,
+	}
+	if mode.IsDir() {
+		fi.children = map[string]*MockFileInfo{}
+		fi.nlink = 1 // The entry for "." in the new directory.
+	}
+	mf.link(dir, filename, fi)
+	return fi
+}
+
+// Adds a copy of a file from another filesystem, described by fi, as name.
+// OverlayFilesystem uses this to copy files up with the privileges of
+// whoever mounted it: no permission checks are made, and the copy keeps
+// the mode, modification time and, where Sys reports it, owner of fi.
+// data holds the contents of a regular file or the target of a link.
+func (mf *MockFilesystem) copyIn(name string, fi os.FileInfo, data []byte) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	creds := mf.creds
+	mf.creds = nil
+	defer func() { mf.creds = creds }()
+	dir, filename, err := mf.resolveParent(name)
+	if err != nil {
+		return newPathError("open", name, err)
+	}
+	if filename == "" || isDots(filename) || dir.Child(filename) != nil {
+		return newPathError("open", name, syscall.EEXIST)
+	}
+	mode := fi.Mode()
+	switch {
+	case mode.IsDir():
+		data = []byte{}
+	case mode&os.ModeSymlink != 0:
+		mode = os.ModeSymlink | 0777
+	case !mode.IsRegular():
+		return newPathError("open", name, errNotSupported)
+	}
+	mode &= os.ModeDir | os.ModeSymlink | os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	copied := mf.create(dir, filename, mode, data)
+	if uid, gid, ok := fileOwner(fi); ok {
+		copied.uid, copied.gid = uid, gid
+	} else if creds != nil {
+		copied.uid, copied.gid = creds.Uid, creds.Gid
+	}
+	copied.atime = fi.ModTime()
+	copied.mtime = fi.ModTime()
+	return nil
+}
+
+// Adds fi to dir as filename.  A directory counts as a link to its parent
+// through its ".." entry; anything else counts as a link to its own node.
+func (mf *MockFilesystem) link(dir *MockFileInfo, filename string, fi *MockFileInfo) {
+	fi.name = filename
+	fi.parent = dir
+	now := mf.clock.Now()
+	dir.children[filename] = fi
+	dir.modified(now)
+	if fi.IsDir() {
+		dir.nlink++
+	}
+	fi.nlink++
+	fi.changed(now)
+}
+
+// Removes fi from its parent directory, reversing link.
+func (mf *MockFilesystem) unlink(fi *MockFileInfo) {
+	dir := fi.Parent()
+	now := mf.clock.Now()
+	delete(dir.children, fi.name)
+	dir.modified(now)
+	if fi.IsDir() {
+		dir.nlink--
+	}
+	fi.nlink--
+	fi.changed(now)
+}
+
+// Unlinks fi for good.  The node's data is discarded once it has no links
+// and is no longer open.
+func (mf *MockFilesystem) remove(fi *MockFileInfo) {
+	mf.unlink(fi)
+	if fi.IsDir() {
+		fi.nlink = 0
+	}
+	fi.release()
+}
+
+// Removes fi and everything below it.  The caller checks permissions with
+// mayDeleteAll first.
+func (mf *MockFilesystem) removeAll(fi *MockFileInfo) {
+	for _, child := range fi.children {
+		mf.removeAll(child)
+	}
+	mf.remove(fi)
+}
+
 func (mf *MockFilesystem) exists(path string) bool {
 	_, err := mf.resolve(path)
 	return err == nil
 }
 
 func (mf *MockFilesystem) Chdir(dir string) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
 	fi, err := mf.resolve(dir)
-	if err == nil {
-		if fi.IsDir() {
-			mf.cwd = fi
-			return nil
-		} else {
-			return GetPathError(dir, "Path is not a directory")
-		}
+	if err != nil {
+		return newPathError("chdir", dir, err)
 	}
-	return err
+	if !fi.IsDir() {
+		return newPathError("chdir", dir, syscall.ENOTDIR)
+	}
+	if err = mf.access(fi, permExec); err != nil {
+		return newPathError("chdir", dir, err)
+	}
+	mf.cwd = fi
+	return nil
 }
 
 func (mf *MockFilesystem) Mkdir(name string, perm os.FileMode) error {
-	path := mf.getpath(name)
-	parentpath, dirname := filepath.Split(path)
-	fi, err := mf.resolve(parentpath)
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	return mf.mkdir(name, perm)
+}
+
+func (mf *MockFilesystem) mkdir(name string, perm os.FileMode) error {
+	fi, dirname, err := mf.resolveParent(name)
 	if err != nil {
-		return err
+		return newPathError("mkdir", name, err)
 	}
-	if child := fi.Child(dirname); child != nil {
-		return nil // Path already exists
+	if dirname == "" || isDots(dirname) || fi.Child(dirname) != nil {
+		return newPathError("mkdir", name, syscall.EEXIST)
 	}
-	fi.children[dirname] = &MockFileInfo{
-		name:       dirname,
-		filesystem: mf,
-		mode:       perm | os.ModeDir,
-		modified:   time.Now(),
-		buf:        []byte{},
-		parent:     fi,
-		children:   map[string]*MockFileInfo{},
+	if err = mf.access(fi, permWrite|permExec); err != nil {
+		return newPathError("mkdir", name, err)
 	}
-	fi.modified = time.Now() // Update directory's timestamp.
+	mf.create(fi, dirname, perm&^mf.umask|os.ModeDir, []byte{})
 	return nil
 }
 
+// Creates each missing directory in path.  Existing directories are left
+// untouched, but an existing file in path is an error.
 func (mf *MockFilesystem) MkdirAll(path string, perm os.FileMode) error {
-	path = mf.getpath(path)
-	parts := strings.Split(path, string(filepath.Separator))
-	base := "/"
-	for _, part := range parts {
-		if part == "" {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	path = filepath.Clean(path)
+	for i := 1; i <= len(path); i++ {
+		if i < len(path) && path[i] != filepath.Separator {
 			continue
 		}
-		base = filepath.Join(base, part)
-		if err := mf.Mkdir(base, perm); err != nil {
-			return err
+		base := path[:i]
+		fi, err := mf.resolve(base)
+		if err == syscall.ENOENT {
+			if err = mf.mkdir(base, perm); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return newPathError("mkdir", base, err)
+		}
+		if !fi.IsDir() {
+			return newPathError("mkdir", base, syscall.ENOTDIR)
 		}
 	}
 	return nil
 }
 
+// Removes the named file or empty directory.  A symbolic link is removed
+// rather than its target.
 func (mf *MockFilesystem) Remove(name string) error {
-	fi, err := mf.resolve(name)
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	if isDots(filepath.Base(name)) {
+		return newPathError("remove", name, syscall.EINVAL)
+	}
+	fi, err := mf.lresolve(name)
 	if err != nil {
-		return err
+		return newPathError("remove", name, err)
+	}
+	if fi == mf.root {
+		return newPathError("remove", name, syscall.EBUSY)
 	}
 	if len(fi.Children()) > 0 {
-		return GetPathError(name, "Directory contains children")
+		return newPathError("remove", name, errNotEmpty)
+	}
+	if err = mf.mayDelete(fi); err != nil {
+		return newPathError("remove", name, err)
 	}
-	delete(fi.Parent().Children(), fi.name)
-	fi.Parent().modified = time.Now()
+	mf.remove(fi)
 	return nil
 }
 
+// Removes path and any children it contains.  A path which does not exist
+// is not an error.
 func (mf *MockFilesystem) RemoveAll(path string) error {
-	fi, err := mf.resolve(path)
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	if isDots(filepath.Base(path)) {
+		return newPathError("RemoveAll", path, syscall.EINVAL)
+	}
+	fi, err := mf.lresolve(path)
+	if err == syscall.ENOENT {
+		return nil
+	}
 	if err != nil {
-		return err
+		return newPathError("unlinkat", path, err)
+	}
+	if fi == mf.root {
+		return newPathError("unlinkat", path, syscall.EBUSY)
 	}
-	delete(fi.Parent().Children(), fi.name)
-	fi.Parent().modified = time.Now()
+	if err = mf.mayDeleteAll(fi); err != nil {
+		return newPathError("unlinkat", path, err)
+	}
+	mf.removeAll(fi)
 	return nil
 }
 
+// Moves the file or directory at oldname to newname.  An existing file at
+// newname is replaced, as is an existing empty directory if oldname is
+// also a directory.  Open files and the paths of any moved children remain
+// valid after the move.  Symbolic links are moved rather than followed.
 func (mf *MockFilesystem) Rename(oldname string, newname string) error {
-	return errors.New("Not implemented")
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	var (
+		src      *MockFileInfo
+		dst      *MockFileInfo
+		dir      *MockFileInfo
+		filename string
+		err      error
+	)
+	if src, err = mf.lresolve(oldname); err != nil {
+		return newLinkError("rename", oldname, newname, err)
+	}
+	if dir, filename, err = mf.resolveParent(newname); err != nil {
+		return newLinkError("rename", oldname, newname, err)
+	}
+	if src == mf.root || filename == "" {
+		return newLinkError("rename", oldname, newname, syscall.EBUSY)
+	}
+	if isDots(filepath.Base(oldname)) || isDots(filename) {
+		return newLinkError("rename", oldname, newname, syscall.EINVAL)
+	}
+	for ptr := dir; ptr != nil; ptr = ptr.Parent() {
+		if ptr == src {
+			return newLinkError("rename", oldname, newname, syscall.EINVAL)
+		}
+	}
+	if dst = dir.Child(filename); dst != nil {
+		if dst.mockNode == src.mockNode {
+			return nil
+		}
+		if src.IsDir() {
+			if !dst.IsDir() {
+				return newLinkError("rename", oldname, newname, syscall.ENOTDIR)
+			}
+			if len(dst.Children()) > 0 {
+				return newLinkError("rename", oldname, newname, errNotEmpty)
+			}
+		} else if dst.IsDir() {
+			return newLinkError("rename", oldname, newname, syscall.EISDIR)
+		}
+	}
+	if err = mf.mayRename(src, dir, dst); err != nil {
+		return newLinkError("rename", oldname, newname, err)
+	}
+	if dst != nil {
+		mf.remove(dst)
+	}
+	mf.unlink(src)
+	mf.link(dir, filename, src)
+	return nil
 }
 
 func (mf *MockFilesystem) Create(name string) (file File, err error) {
-	path := mf.getpath(name)
-	dir, filename := filepath.Split(path)
-	fi, err := mf.resolve(dir)
-	if err != nil {
-		return nil, err
-	}
-	fi.children[filename] = &MockFileInfo{
-		name:       filename,
-		filesystem: mf,
-		mode:       0666,
-		modified:   time.Now(),
-		buf:        []byte{},
-		parent:     fi,
-		children:   nil,
+	return mf.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (mf *MockFilesystem) Open(name string) (file File, err error) {
+	return mf.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Opens the named file using the os.O_* values in flag.  If the file does
+// not exist and os.O_CREATE is set, it is created with the permission bits
+// of perm, following a dangling symbolic link if necessary.  With
+// os.O_EXCL a symbolic link is never followed and the call fails if the
+// name exists.  The access mode in flag is enforced by the returned file.
+func (mf *MockFilesystem) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	var (
+		fi       *MockFileInfo
+		dir      *MockFileInfo
+		filename string
+	)
+	if fi, err = mf.resolve(name); err != nil {
+		if err != syscall.ENOENT || flag&os.O_CREATE == 0 {
+			return nil, newPathError("open", name, err)
+		}
+		if flag&os.O_EXCL != 0 {
+			if dir, filename, err = mf.resolveExclusive(name); err != nil {
+				return nil, newPathError("open", name, err)
+			}
+		} else if dir, filename, err = mf.resolveCreate(name); err != nil {
+			return nil, newPathError("open", name, err)
+		}
+		if err = mf.access(dir, permWrite|permExec); err != nil {
+			return nil, newPathError("open", name, err)
+		}
+		perm &= os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+		perm &^= mf.umask
+		fi = mf.create(dir, filename, perm, []byte{})
+	} else {
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, newPathError("open", name, syscall.EEXIST)
+		}
+		if fi.IsDir() && (isWritable(flag) || flag&os.O_TRUNC != 0) {
+			return nil, newPathError("open", name, syscall.EISDIR)
+		}
+		want := os.FileMode(0)
+		if isReadable(flag) {
+			want |= permRead
+		}
+		if isWritable(flag) || flag&os.O_TRUNC != 0 {
+			want |= permWrite
+		}
+		if err = mf.access(fi, want); err != nil {
+			return nil, newPathError("open", name, err)
+		}
+		if flag&os.O_TRUNC != 0 {
+			fi.buf = []byte{}
+			fi.modified(mf.clock.Now())
+		}
 	}
-	fi.modified = time.Now()
+	fi.opens++
 	f := &MockFile{
 		filesystem: mf,
-		fi:         fi.children[filename],
-		path:       path,
+		fi:         fi,
+		path:       name,
 		off:        0,
+		flag:       flag,
+		fd:         mf.nextFd(),
 	}
 	return f, nil
 }
 
-func (mf *MockFilesystem) Open(name string) (file File, err error) {
+func (mf *MockFilesystem) Stat(name string) (fi os.FileInfo, err error) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	mfi, err := mf.resolve(name)
+	if err != nil {
+		return nil, newPathError("stat", name, err)
+	}
+	return mfi.snapshot(), nil
+}
+
+// Like Stat, but describes a symbolic link itself rather than its target.
+func (mf *MockFilesystem) Lstat(name string) (fi os.FileInfo, err error) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	mfi, err := mf.lresolve(name)
+	if err != nil {
+		return nil, newPathError("lstat", name, err)
+	}
+	return mfi.snapshot(), nil
+}
+
+// Creates newname as a symbolic link to oldname.  The target is stored
+// verbatim and is not required to exist.
+func (mf *MockFilesystem) Symlink(oldname string, newname string) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	dir, filename, err := mf.resolveParent(newname)
+	if err != nil {
+		return newLinkError("symlink", oldname, newname, err)
+	}
+	if filename == "" || isDots(filename) || dir.Child(filename) != nil {
+		return newLinkError("symlink", oldname, newname, syscall.EEXIST)
+	}
+	if err = mf.access(dir, permWrite|permExec); err != nil {
+		return newLinkError("symlink", oldname, newname, err)
+	}
+	if oldname == "" {
+		return newLinkError("symlink", oldname, newname, syscall.ENOENT)
+	}
+	mf.create(dir, filename, os.ModeSymlink|0777, []byte(oldname))
+	return nil
+}
+
+// Returns the target of the named symbolic link.
+func (mf *MockFilesystem) Readlink(name string) (string, error) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	fi, err := mf.lresolve(name)
+	if err != nil {
+		return "", newPathError("readlink", name, err)
+	}
+	if !fi.isSymlink() {
+		return "", newPathError("readlink", name, syscall.EINVAL)
+	}
+	fi.accessed(mf.clock.Now())
+	return string(fi.buf), nil
+}
+
+// Sets the access and modification times of the named file, following
+// symbolic links.  A zero time leaves the corresponding timestamp alone.
+func (mf *MockFilesystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
 	fi, err := mf.resolve(name)
 	if err != nil {
-		return nil, err
+		return newPathError("chtimes", name, err)
 	}
-	f := &MockFile{
+	if (!atime.IsZero() || !mtime.IsZero()) && !mf.isOwner(fi) {
+		return newPathError("chtimes", name, syscall.EPERM)
+	}
+	if !atime.IsZero() {
+		fi.atime = atime
+	}
+	if !mtime.IsZero() {
+		fi.mtime = mtime
+	}
+	fi.changed(mf.clock.Now())
+	return nil
+}
+
+// Changes the numeric uid and gid of the named file, following symbolic
+// links.  A uid or gid of -1 is left unchanged.
+func (mf *MockFilesystem) Chown(name string, uid int, gid int) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	fi, err := mf.resolve(name)
+	if err != nil {
+		return newPathError("chown", name, err)
+	}
+	if err = mf.chown(fi, uid, gid); err != nil {
+		return newPathError("chown", name, err)
+	}
+	return nil
+}
+
+// Changes the numeric uid and gid of the named file.  A symbolic link is
+// changed itself rather than followed.
+func (mf *MockFilesystem) Lchown(name string, uid int, gid int) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	fi, err := mf.lresolve(name)
+	if err != nil {
+		return newPathError("lchown", name, err)
+	}
+	if err = mf.chown(fi, uid, gid); err != nil {
+		return newPathError("lchown", name, err)
+	}
+	return nil
+}
+
+// Creates newname as a hard link to oldname, sharing its contents.  As on
+// Linux, a symbolic link at oldname is linked rather than followed.
+func (mf *MockFilesystem) Link(oldname string, newname string) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	src, err := mf.lresolve(oldname)
+	if err != nil {
+		return newLinkError("link", oldname, newname, err)
+	}
+	if src.IsDir() {
+		return newLinkError("link", oldname, newname, syscall.EPERM)
+	}
+	dir, filename, err := mf.resolveParent(newname)
+	if err != nil {
+		return newLinkError("link", oldname, newname, err)
+	}
+	if filename == "" || isDots(filename) || dir.Child(filename) != nil {
+		return newLinkError("link", oldname, newname, syscall.EEXIST)
+	}
+	if err = mf.access(dir, permWrite|permExec); err != nil {
+		return newLinkError("link", oldname, newname, err)
+	}
+	fi := &MockFileInfo{
+		mockNode:   src.mockNode,
 		filesystem: mf,
-		fi:         fi,
-		path:       name,
-		off:        0,
 	}
-	return f, nil
+	mf.link(dir, filename, fi)
+	return nil
 }
 
-func (mf *MockFilesystem) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
-	return nil, errors.New("Not implemented")
+// Returns the absolute path of the working directory.
+func (mf *MockFilesystem) Getwd() (dir string, err error) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	for ptr := mf.cwd; ptr != mf.root; ptr = ptr.parent {
+		if ptr.parent.children[ptr.name] != ptr {
+			return "", os.NewSyscallError("getwd", syscall.ENOENT)
+		}
+	}
+	return mf.cwd.path(), nil
 }
 
-func (mf *MockFilesystem) Stat(name string) (fi os.FileInfo, err error) {
+// Changes the permission bits of the named file, following symbolic links.
+func (mf *MockFilesystem) Chmod(name string, mode os.FileMode) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	fi, err := mf.resolve(name)
+	if err != nil {
+		return newPathError("chmod", name, err)
+	}
+	if err = mf.chmod(fi, mode); err != nil {
+		return newPathError("chmod", name, err)
+	}
+	return nil
+}
+
+// Changes the size of the named file, following symbolic links.
+func (mf *MockFilesystem) Truncate(name string, size int64) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	if size < 0 {
+		return newPathError("truncate", name, syscall.EINVAL)
+	}
+	fi, err := mf.resolve(name)
+	if err != nil {
+		return newPathError("truncate", name, err)
+	}
+	if fi.IsDir() {
+		return newPathError("truncate", name, syscall.EISDIR)
+	}
+	if err = mf.access(fi, permWrite); err != nil {
+		return newPathError("truncate", name, err)
+	}
+	if err = fi.resize(size); err != nil {
+		return newPathError("truncate", name, err)
+	}
+	fi.modified(mf.clock.Now())
+	return nil
+}
+
+// Returns the entries of the named directory sorted by name.
+func (mf *MockFilesystem) ReadDir(name string) ([]os.DirEntry, error) {
+	f, err := mf.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	entries := make([]os.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = fs.FileInfoToDirEntry(info)
+	}
+	return entries, err
+}
+
+// Returns the contents of the named file.
+func (mf *MockFilesystem) ReadFile(name string) ([]byte, error) {
 	f, err := mf.Open(name)
 	if err != nil {
 		return nil, err
 	}
-	return f.Stat()
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Writes data to the named file, creating it with perm (before umask) if
+// necessary and truncating it otherwise.
+func (mf *MockFilesystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	f, err := mf.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// The directory used for temporary files when none is given.  Unlike a
+// real system, the mock does not create it automatically.
+func (mf *MockFilesystem) TempDir() string {
+	return "/tmp"
+}
+
+// Returns the prefix and suffix around the last "*" in a MkdirTemp or
+// CreateTemp pattern, with the prefix joined to dir.  A pattern without
+// "*" is used as the prefix.
+func tempPattern(dir string, pattern string) (prefix string, suffix string, err error) {
+	if strings.Contains(pattern, "/") {
+		return "", "", errPatternHasSeparator
+	}
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	} else {
+		prefix = pattern
+	}
+	if strings.HasSuffix(dir, "/") {
+		return dir + prefix, suffix, nil
+	}
+	return dir + "/" + prefix, suffix, nil
+}
+
+// Returns the next temporary name.  Names are numbered sequentially so
+// that tests see the same names on every run.
+func (mf *MockFilesystem) tempName(prefix string, suffix string) string {
+	return prefix + strconv.FormatUint(atomic.AddUint64(&mf.temp, 1), 10) + suffix
+}
+
+// Creates a new directory in dir, or TempDir if dir is empty, with a name
+// made by replacing the last "*" in pattern with a number.  Returns the
+// path of the new directory.
+func (mf *MockFilesystem) MkdirTemp(dir string, pattern string) (string, error) {
+	if dir == "" {
+		dir = mf.TempDir()
+	}
+	prefix, suffix, err := tempPattern(dir, pattern)
+	if err != nil {
+		return "", newPathError("mkdirtemp", pattern, err)
+	}
+	for try := 0; try < maxTempTries; try++ {
+		name := mf.tempName(prefix, suffix)
+		if err = mf.Mkdir(name, 0700); err == nil {
+			return name, nil
+		} else if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+	}
+	return "", newPathError("mkdirtemp", prefix+"*"+suffix, syscall.EEXIST)
+}
+
+// Creates and opens a new file for reading and writing in dir, or TempDir
+// if dir is empty, named like MkdirTemp names directories.
+func (mf *MockFilesystem) CreateTemp(dir string, pattern string) (file File, err error) {
+	if dir == "" {
+		dir = mf.TempDir()
+	}
+	prefix, suffix, err := tempPattern(dir, pattern)
+	if err != nil {
+		return nil, newPathError("createtemp", pattern, err)
+	}
+	for try := 0; try < maxTempTries; try++ {
+		name := mf.tempName(prefix, suffix)
+		if file, err = mf.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600); err == nil {
+			return file, nil
+		} else if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+	}
+	return nil, newPathError("createtemp", prefix+"*"+suffix, syscall.EEXIST)
 }
 
 // Prints the filesystem to stdout, useful for testing.
@@ -240,7 +1001,7 @@ func (mf *MockFilesystem) Print() {
 		dirs = dirs[1:]
 		f, _ = mf.Open(path)
 		fi, _ = f.Stat()
-		files, _ = f.Readdir(100)
+		files, _ = f.Readdir(-1)
 		for _, fi = range files {
 			name := filepath.Join(path, fi.Name())
 			line := []interface{}{name, fi.Mode(), fi.IsDir()}
@@ -260,115 +1021,252 @@ func (mf *MockFilesystem) Print() {
 }
 
 type MockFile struct {
+	mu         sync.Mutex
 	path       string
 	fi         *MockFileInfo
 	filesystem *MockFilesystem
 	off        int64
+	flag       int
+	dirents    []*MockFileInfo
+	dirlisted  bool
+	fd         uintptr
 }
 
-func (mf *MockFile) grow(n int) (err error) {
-	if mf.fi == nil {
-		return ErrFileClosed
+func isReadable(flag int) bool {
+	return flag&(os.O_RDONLY|os.O_WRONLY|os.O_RDWR) != os.O_WRONLY
+}
+
+func isWritable(flag int) bool {
+	mode := flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	return mode == os.O_WRONLY || mode == os.O_RDWR
+}
+
+// Acquires the file's lock and then the filesystem's.
+func (mf *MockFile) lock() {
+	mf.mu.Lock()
+	mf.filesystem.mu.Lock()
+}
+
+func (mf *MockFile) unlock() {
+	mf.filesystem.mu.Unlock()
+	mf.mu.Unlock()
+}
+
+func (mf *MockFile) pathError(op string, err error) error {
+	return newPathError(op, mf.path, err)
+}
+
+func (mf *MockFile) read(b []byte, off int64) (n int, err error) {
+	var mfi *MockFileInfo
+	if mfi, err = mf.stat(); err != nil {
+		return 0, mf.pathError("read", err)
 	}
-	if len(mf.fi.buf)+n > cap(mf.fi.buf) {
-		var buf []byte
-		defer func() {
-			if recover() != nil {
-				err = ErrTooLarge
-			}
-		}()
-		buf = make([]byte, 2*cap(mf.fi.buf)+n)
-		copy(buf, mf.fi.buf)
-		mf.fi.buf = buf
+	if !isReadable(mf.flag) {
+		return 0, mf.pathError("read", errBadFd)
+	}
+	if mfi.IsDir() {
+		return 0, mf.pathError("read", syscall.EISDIR)
+	}
+	if off >= int64(len(mfi.buf)) {
+		if len(b) == 0 {
+			return
+		}
+		mfi.accessed(mf.filesystem.clock.Now())
+		return 0, io.EOF
 	}
+	n = copy(b, mfi.buf[off:])
+	mfi.accessed(mf.filesystem.clock.Now())
 	return
 }
 
+func (mf *MockFile) write(b []byte, off int64) (n int, err error) {
+	if mf.fi == nil {
+		return 0, mf.pathError("write", ErrFileClosed)
+	}
+	if !isWritable(mf.flag) {
+		return 0, mf.pathError("write", errBadFd)
+	}
+	if end := off + int64(len(b)); end > int64(len(mf.fi.buf)) {
+		if err = mf.fi.resize(end); err != nil {
+			return 0, mf.pathError("write", err)
+		}
+	}
+	n = copy(mf.fi.buf[off:], b)
+	if n > 0 {
+		mf.fi.modified(mf.filesystem.clock.Now())
+	}
+	return n, nil
+}
+
 func (mf *MockFile) stat() (mfi *MockFileInfo, err error) {
-	if mf.fi != nil {
-		return mf.fi, nil
+	if mf.fi == nil {
+		return nil, ErrFileClosed
 	}
-	return mf.filesystem.resolve(mf.path)
+	return mf.fi, nil
 }
 
 func (mf *MockFile) Chdir() error {
-	return mf.filesystem.Chdir(filepath.Dir(mf.path))
+	mf.lock()
+	defer mf.unlock()
+	var (
+		mfi *MockFileInfo
+		err error
+	)
+	if mfi, err = mf.stat(); err != nil {
+		return mf.pathError("chdir", err)
+	}
+	if !mfi.IsDir() {
+		return mf.pathError("chdir", syscall.ENOTDIR)
+	}
+	if err = mf.filesystem.access(mfi, permExec); err != nil {
+		return mf.pathError("chdir", err)
+	}
+	mf.filesystem.cwd = mfi
+	return nil
 }
 
 func (mf *MockFile) Chmod(mode os.FileMode) error {
+	mf.lock()
+	defer mf.unlock()
 	var (
 		mfi *MockFileInfo
 		err error
 	)
 	if mfi, err = mf.stat(); err != nil {
-		return err
+		return mf.pathError("chmod", err)
+	}
+	if err = mf.filesystem.chmod(mfi, mode); err != nil {
+		return mf.pathError("chmod", err)
+	}
+	return nil
+}
+
+func (mf *MockFile) Chown(uid int, gid int) error {
+	mf.lock()
+	defer mf.unlock()
+	var (
+		mfi *MockFileInfo
+		err error
+	)
+	if mfi, err = mf.stat(); err != nil {
+		return mf.pathError("chown", err)
+	}
+	if err = mf.filesystem.chown(mfi, uid, gid); err != nil {
+		return mf.pathError("chown", err)
 	}
-	mfi.mode = mode
 	return nil
 }
 
 func (mf *MockFile) Close() error {
+	mf.lock()
+	defer mf.unlock()
+	if mf.fi == nil {
+		return mf.pathError("close", ErrFileClosed)
+	}
+	mf.fi.opens--
+	mf.fi.release()
 	mf.fi = nil
 	mf.off = 0
 	return nil
 }
 
+// Returns the fake descriptor number assigned when the file was opened,
+// or ^uintptr(0) once it is closed.  The number does not refer to a real
+// descriptor, and is chosen so that it cannot collide with one.
+func (mf *MockFile) Fd() uintptr {
+	mf.lock()
+	defer mf.unlock()
+	if mf.fi == nil {
+		return ^uintptr(0)
+	}
+	return mf.fd
+}
+
+// Returns the name the file was opened with, as *os.File does.
 func (mf *MockFile) Name() string {
-	_, filename := filepath.Split(mf.path)
-	return filename
+	return mf.path
 }
 
 func (mf *MockFile) Read(b []byte) (n int, err error) {
-	var mfi *MockFileInfo
-	if mfi, err = mf.stat(); err != nil {
-		return 0, err
-	}
-	if mf.off >= int64(len(mfi.buf)) {
-		if len(b) == 0 {
-			return
-		}
-		return 0, io.EOF
-	}
-	n = copy(b, mfi.buf[mf.off:])
+	mf.lock()
+	defer mf.unlock()
+	n, err = mf.read(b, mf.off)
 	mf.off += int64(n)
 	return
 }
 
+// Reads from the given offset without changing the file's offset.
 func (mf *MockFile) ReadAt(b []byte, off int64) (n int, err error) {
-	mf.off = off
-	return mf.Read(b)
+	mf.lock()
+	defer mf.unlock()
+	if off < 0 {
+		return 0, mf.pathError("readat", ErrOutOfRange)
+	}
+	if n, err = mf.read(b, off); err == nil && n < len(b) {
+		err = io.EOF
+	}
+	return
 }
 
+// Returns up to n entries of the directory, continuing from where the
+// previous call left off.  Entries are returned sorted by name.  If n > 0
+// and no entries remain, io.EOF is returned.  If n <= 0, all remaining
+// entries are returned with a nil error.
 func (mf *MockFile) Readdir(n int) (fi []os.FileInfo, err error) {
-	// TODO: Enable returning additional elements in subsequent calls.
-	var (
-		mfi *MockFileInfo
-	)
-	fi = make([]os.FileInfo, 0)
-	if mfi, err = mf.stat(); err != nil {
-		return nil, err
-	}
-	limit := len(mfi.children)
-	if n > 0 {
-		limit = n
+	mf.lock()
+	defer mf.unlock()
+	if mf.fi == nil {
+		return nil, mf.pathError("readdir", ErrFileClosed)
 	}
-	if len(mfi.children) < limit {
-		err = io.EOF
+	if !mf.fi.IsDir() {
+		return nil, mf.pathError("readdirent", syscall.ENOTDIR)
 	}
-	if len(mfi.children) == 0 {
-		return
+	if !mf.dirlisted {
+		names := make([]string, 0, len(mf.fi.children))
+		for name := range mf.fi.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		mf.dirents = make([]*MockFileInfo, len(names))
+		for i, name := range names {
+			mf.dirents[i] = mf.fi.children[name]
+		}
+		mf.dirlisted = true
 	}
-	i := 0
-	for _, child := range mfi.children {
-		if i == limit {
-			break
+	mf.fi.accessed(mf.filesystem.clock.Now())
+	limit := len(mf.dirents)
+	if n > 0 {
+		if limit == 0 {
+			return []os.FileInfo{}, io.EOF
 		}
-		fi = append(fi, child)
-		i++
+		if n < limit {
+			limit = n
+		}
+	}
+	fi = make([]os.FileInfo, limit)
+	for i, child := range mf.dirents[:limit] {
+		fi[i] = child.snapshot()
 	}
+	mf.dirents = mf.dirents[limit:]
 	return
 }
 
+// Like Readdir, but returns fs.DirEntry values.
+func (mf *MockFile) ReadDir(n int) (entries []fs.DirEntry, err error) {
+	fi, err := mf.Readdir(n)
+	entries = make([]fs.DirEntry, len(fi))
+	for i, f := range fi {
+		entries[i] = fs.FileInfoToDirEntry(f)
+	}
+	return entries, err
+}
+
+// Copies r into the file until EOF, as io.Copy would.  Implements
+// io.ReaderFrom.
+func (mf *MockFile) ReadFrom(r io.Reader) (n int64, err error) {
+	return io.Copy(struct{ io.Writer }{mf}, r)
+}
+
 func (mf *MockFile) Readdirnames(n int) (names []string, err error) {
 	fi, err := mf.Readdir(n)
 	names = make([]string, len(fi))
@@ -379,64 +1277,210 @@ func (mf *MockFile) Readdirnames(n int) (names []string, err error) {
 }
 
 func (mf *MockFile) Seek(offset int64, whence int) (ret int64, err error) {
+	mf.lock()
+	defer mf.unlock()
 	if mf.fi == nil {
-		return mf.off, ErrFileClosed
+		return 0, mf.pathError("seek", ErrFileClosed)
 	}
 	switch whence {
-	case 0:
-		mf.off = offset
-	case 1:
-		mf.off += offset
-	case 2:
-		mf.off = int64(len(mf.fi.buf)) + offset
+	case io.SeekStart:
+		ret = offset
+	case io.SeekCurrent:
+		ret = mf.off + offset
+	case io.SeekEnd:
+		ret = int64(len(mf.fi.buf)) + offset
+	default:
+		return 0, mf.pathError("seek", ErrOutOfRange)
+	}
+	if ret < 0 {
+		return 0, mf.pathError("seek", ErrOutOfRange)
+	}
+	if ret == 0 && mf.fi.IsDir() {
+		mf.dirents = nil
+		mf.dirlisted = false
 	}
-	return mf.off, nil
+	mf.off = ret
+	return
+}
+
+func (mf *MockFile) setDeadline(op string) error {
+	mf.lock()
+	defer mf.unlock()
+	if mf.fi == nil {
+		return mf.pathError(op, ErrFileClosed)
+	}
+	return os.ErrNoDeadline
+}
+
+// Regular files and directories, the only kinds of file the mock holds,
+// never block and so do not support deadlines.  As with *os.File, the
+// deadline setters return os.ErrNoDeadline for them.
+func (mf *MockFile) SetDeadline(t time.Time) error {
+	return mf.setDeadline("SetDeadline")
+}
+
+func (mf *MockFile) SetReadDeadline(t time.Time) error {
+	return mf.setDeadline("SetReadDeadline")
+}
+
+func (mf *MockFile) SetWriteDeadline(t time.Time) error {
+	return mf.setDeadline("SetWriteDeadline")
 }
 
 func (mf *MockFile) Stat() (fi os.FileInfo, err error) {
-	return mf.stat()
+	mf.lock()
+	defer mf.unlock()
+	mfi, err := mf.stat()
+	if err != nil {
+		return nil, mf.pathError("stat", err)
+	}
+	return mfi.snapshot(), nil
 }
 
 func (mf *MockFile) Sync() (err error) {
+	mf.lock()
+	defer mf.unlock()
+	if mf.fi == nil {
+		return mf.pathError("sync", ErrFileClosed)
+	}
 	return nil
 }
 
+// Fails with ENOTSUP, as the file has no real descriptor for raw system
+// calls to use.
+func (mf *MockFile) SyscallConn() (syscall.RawConn, error) {
+	return nil, mf.pathError("syscallconn", errNotSupported)
+}
+
+// Changes the size of the file, either discarding data past size or
+// extending the file with zeroes.  The file's offset is not changed.
 func (mf *MockFile) Truncate(size int64) error {
+	mf.lock()
+	defer mf.unlock()
 	if mf.fi == nil {
-		return ErrFileClosed
+		return mf.pathError("truncate", ErrFileClosed)
+	}
+	if !isWritable(mf.flag) || size < 0 {
+		return mf.pathError("truncate", syscall.EINVAL)
 	}
-	if size < 0 || size > int64(len(mf.fi.buf)) {
-		return ErrOutOfRange
+	if err := mf.fi.resize(size); err != nil {
+		return mf.pathError("truncate", err)
 	}
-	mf.fi.buf = mf.fi.buf[0:size]
+	mf.fi.modified(mf.filesystem.clock.Now())
 	return nil
 }
 
 func (mf *MockFile) Write(b []byte) (n int, err error) {
-	if mf.fi == nil {
-		return 0, ErrFileClosed
+	mf.lock()
+	defer mf.unlock()
+	if mf.fi != nil && mf.flag&os.O_APPEND != 0 {
+		mf.off = int64(len(mf.fi.buf))
 	}
-	if err = mf.grow(len(b)); err != nil {
-		return
-	}
-	return copy(mf.fi.buf[mf.off:], b), nil
+	n, err = mf.write(b, mf.off)
+	mf.off += int64(n)
+	return
 }
 
+// Writes at the given offset without changing the file's offset.
 func (mf *MockFile) WriteAt(b []byte, off int64) (n int, err error) {
-	mf.off = off
-	return mf.Write(b)
+	mf.lock()
+	defer mf.unlock()
+	if mf.flag&os.O_APPEND != 0 {
+		return 0, ErrAppendMode
+	}
+	if off < 0 {
+		return 0, mf.pathError("writeat", ErrOutOfRange)
+	}
+	return mf.write(b, off)
+}
+
+// Copies the rest of the file to w, as io.Copy would.  Implements
+// io.WriterTo.
+func (mf *MockFile) WriteTo(w io.Writer) (n int64, err error) {
+	return io.Copy(w, struct{ io.Reader }{mf})
 }
 
 func (mf *MockFile) WriteString(s string) (ret int, err error) {
 	return mf.Write([]byte(s))
 }
 
+// The contents and metadata of a file, shared by each of its hard links.
+// Timestamps follow Linux: atime records reads, mtime records changes to
+// the contents and ctime records any change to the node.
+type mockNode struct {
+	buf    []byte
+	mode   os.FileMode
+	atime  time.Time
+	mtime  time.Time
+	ctime  time.Time
+	nlink  int
+	opens  int
+	ino    uint64
+	uid    int
+	gid    int
+	xattrs map[string][]byte
+}
+
+func (n *mockNode) accessed(now time.Time) {
+	n.atime = now
+}
+
+func (n *mockNode) modified(now time.Time) {
+	n.mtime = now
+	n.ctime = now
+}
+
+func (n *mockNode) changed(now time.Time) {
+	n.ctime = now
+}
+
+// Sets the length of the file to size, filling any newly exposed bytes
+// with zeroes.
+func (n *mockNode) resize(size int64) (err error) {
+	if size <= int64(len(n.buf)) {
+		n.buf = n.buf[0:size]
+		return
+	}
+	if int64(int(size)) != size {
+		return ErrTooLarge
+	}
+	if size > int64(cap(n.buf)) {
+		var buf []byte
+		defer func() {
+			if recover() != nil {
+				err = ErrTooLarge
+			}
+		}()
+		capacity := 2 * cap(n.buf)
+		if capacity < int(size) {
+			capacity = int(size)
+		}
+		buf = make([]byte, size, capacity)
+		copy(buf, n.buf)
+		n.buf = buf
+		return
+	}
+	end := len(n.buf)
+	n.buf = n.buf[0:size]
+	clear(n.buf[end:])
+	return
+}
+
+// Discards the node's contents if it has been unlinked and is not open.
+func (n *mockNode) release() {
+	if n.nlink <= 0 && n.opens <= 0 {
+		n.buf = nil
+		n.xattrs = nil
+	}
+}
+
+// A directory entry for a mockNode.  Entries within the tree are guarded by
+// the filesystem's lock; copies made with snapshot are handed to callers.
+// Sys returns a *syscall.Stat_t on Linux and nil on other platforms.
 type MockFileInfo struct {
-	buf        []byte
+	*mockNode
 	name       string
 	filesystem *MockFilesystem
-	mode       os.FileMode
-	modified   time.Time
 	parent     *MockFileInfo
 	children   map[string]*MockFileInfo
 }
@@ -451,6 +1495,36 @@ func (mfi *MockFileInfo) path() string {
 	return filepath
 }
 
+// Reports whether fi1 and fi2 describe the same file.  Values from a
+// MockFilesystem are the same file if they are links to the same node, on
+// every platform.  os.SameFile cannot be used for them, as it only
+// recognizes values created by the os package, which is used to compare
+// any other values.
+func SameFile(fi1 os.FileInfo, fi2 os.FileInfo) bool {
+	mfi1, ok1 := fi1.(*MockFileInfo)
+	mfi2, ok2 := fi2.(*MockFileInfo)
+	if ok1 || ok2 {
+		return ok1 && ok2 && mfi1.filesystem == mfi2.filesystem && mfi1.ino == mfi2.ino
+	}
+	return os.SameFile(fi1, fi2)
+}
+
+// Returns a copy of the entry and its node's metadata which remains
+// unchanged by later operations on the filesystem.
+func (mfi *MockFileInfo) snapshot() *MockFileInfo {
+	node := *mfi.mockNode
+	return &MockFileInfo{
+		mockNode:   &node,
+		name:       mfi.name,
+		filesystem: mfi.filesystem,
+		parent:     mfi.parent,
+	}
+}
+
+func (mfi *MockFileInfo) isSymlink() bool {
+	return mfi.mode&os.ModeSymlink != 0
+}
+
 func (mfi *MockFileInfo) Parent() *MockFileInfo {
 	return mfi.parent
 }
@@ -476,13 +1550,9 @@ func (mfi *MockFileInfo) Mode() os.FileMode {
 }
 
 func (mfi *MockFileInfo) ModTime() time.Time {
-	return mfi.modified
+	return mfi.mtime
 }
 
 func (mfi *MockFileInfo) IsDir() bool {
 	return mfi.mode.IsDir()
 }
-
-func (mfi *MockFileInfo) Sys() interface{} {
-	return nil
-}
@@ -15,10 +15,17 @@
 package fauxfile
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func ExpectCwd(t *testing.T, expected string, mf *MockFilesystem) {
@@ -94,8 +101,8 @@ func TestCreate(t *testing.T) {
 	mf := NewMockFilesystem()
 	mf.Create("foo.txt")
 	fi := ExpectFile(t, "/foo.txt", mf)
-	if fi.Mode().Perm() != 0666 {
-		t.Fatalf("New file perm %v, expected 0666", fi.Mode().Perm())
+	if fi.Mode().Perm() != 0644 {
+		t.Fatalf("New file perm %v, expected 0644", fi.Mode().Perm())
 	}
 }
 
@@ -225,7 +232,10 @@ func TestFileChdir(t *testing.T) {
 	mf.Create("/foo/bar/baz/foo.txt")
 	f, _ := mf.Open("/foo/bar/baz/foo.txt")
 	ExpectCwd(t, "/", mf)
-	f.Chdir()
+	ExpectPathError(t, "chdir", "/foo/bar/baz/foo.txt", syscall.ENOTDIR, f.Chdir())
+	ExpectCwd(t, "/", mf)
+	dir, _ := mf.Open("/foo/bar/baz")
+	dir.Chdir()
 	ExpectCwd(t, "/foo/bar/baz", mf)
 }
 
@@ -241,8 +251,8 @@ func TestFileChmod(t *testing.T) {
 		t.Fatalf("File expected: %v", err)
 	}
 	fi = ExpectFile(t, "foo.txt", mf)
-	if perm := fi.Mode().Perm(); perm != 0666 {
-		t.Fatalf("New file perm %v, expected 0666", perm)
+	if perm := fi.Mode().Perm(); perm != 0644 {
+		t.Fatalf("New file perm %v, expected 0644", perm)
 	}
 	if err = f.Chmod(0755); err != nil {
 		t.Fatalf("Chmod should not return error: %v", err)
@@ -315,3 +325,1042 @@ func TestWrite(t *testing.T) {
 		t.Fatalf("Read: %v != expected: %v", string(output), input)
 	}
 }
+
+func TestRename(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("foo", 0755)
+	mf.Create("foo/foo.txt")
+	if err := mf.Rename("foo/foo.txt", "bar.txt"); err != nil {
+		t.Fatalf("Rename should not return error: %v", err)
+	}
+	if _, err := mf.resolve("foo/foo.txt"); err == nil {
+		t.Fatalf("Rename did not remove source")
+	}
+	fi := ExpectFile(t, "/bar.txt", mf)
+	ExpectEqual(t, "/bar.txt", fi.path())
+	ExpectEqual(t, "bar.txt", fi.Name())
+}
+
+func TestRenameReplacesFile(t *testing.T) {
+	mf := NewMockFilesystem()
+	f, _ := mf.Create("foo.txt")
+	f.Write([]byte("foo"))
+	mf.Create("bar.txt")
+	if err := mf.Rename("foo.txt", "bar.txt"); err != nil {
+		t.Fatalf("Rename should replace existing file: %v", err)
+	}
+	fi := ExpectFile(t, "/bar.txt", mf)
+	if fi.Size() != 3 {
+		t.Fatalf("Replaced file has size %v, expected 3", fi.Size())
+	}
+	ExpectEqual(t, "1", fmt.Sprintf("%v", len(mf.root.Children())))
+}
+
+func TestRenameDirectory(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.MkdirAll("/foo/bar/baz", 0755)
+	mf.Create("/foo/bar/baz/foo.txt")
+	mf.Mkdir("/qux", 0755)
+	if err := mf.Rename("/foo/bar", "/qux/bar"); err != nil {
+		t.Fatalf("Rename should move directory: %v", err)
+	}
+	ExpectDir(t, "/qux/bar/baz", mf)
+	fi := ExpectFile(t, "/qux/bar/baz/foo.txt", mf)
+	ExpectEqual(t, "/qux/bar/baz/foo.txt", fi.path())
+	if _, err := mf.resolve("/foo/bar"); err == nil {
+		t.Fatalf("Rename did not remove source directory")
+	}
+}
+
+func TestRenameDirectoryOverDirectory(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.MkdirAll("/foo/a", 0755)
+	mf.MkdirAll("/bar/b", 0755)
+	mf.Mkdir("/baz", 0755)
+	mf.Create("/qux.txt")
+	if err := mf.Rename("/foo", "/bar"); err == nil {
+		t.Fatalf("Rename should not replace non-empty directory")
+	}
+	if err := mf.Rename("/foo", "/qux.txt"); err == nil {
+		t.Fatalf("Rename should not replace file with directory")
+	}
+	if err := mf.Rename("/qux.txt", "/baz"); err == nil {
+		t.Fatalf("Rename should not replace directory with file")
+	}
+	if err := mf.Rename("/foo", "/baz"); err != nil {
+		t.Fatalf("Rename should replace empty directory: %v", err)
+	}
+	ExpectDir(t, "/baz/a", mf)
+}
+
+func TestRenameIntoDescendant(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.MkdirAll("/foo/bar", 0755)
+	if err := mf.Rename("/foo", "/foo/bar/foo"); err == nil {
+		t.Fatalf("Rename should not move directory into its descendant")
+	}
+	if err := mf.Rename("/foo", "/foo/foo"); err == nil {
+		t.Fatalf("Rename should not move directory into itself")
+	}
+	ExpectDir(t, "/foo/bar", mf)
+}
+
+func TestRenameOpenFile(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.MkdirAll("/foo", 0755)
+	mf.Mkdir("/bar", 0755)
+	mf.Create("/foo/foo.txt")
+	f, _ := mf.Open("/foo/foo.txt")
+	mf.Rename("/foo/foo.txt", "/bar/bar.txt")
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Stat on renamed file should not return error: %v", err)
+	}
+	ExpectEqual(t, "bar.txt", fi.Name())
+	ExpectEqual(t, "/bar/bar.txt", fi.(*MockFileInfo).path())
+	dir, _ := mf.Open("/bar")
+	mf.Rename("/bar", "/baz")
+	dir.Chdir()
+	ExpectCwd(t, "/baz", mf)
+}
+
+func TestOpenFileCreate(t *testing.T) {
+	mf := NewMockFilesystem()
+	if _, err := mf.OpenFile("foo.txt", os.O_RDWR, 0600); err == nil {
+		t.Fatalf("OpenFile without O_CREATE should not create file")
+	}
+	if _, err := mf.OpenFile("foo.txt", os.O_RDWR|os.O_CREATE, 0600); err != nil {
+		t.Fatalf("OpenFile should create file: %v", err)
+	}
+	fi := ExpectFile(t, "/foo.txt", mf)
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Fatalf("New file perm %v, expected 0600", perm)
+	}
+	if _, err := mf.OpenFile("foo.txt", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600); err == nil {
+		t.Fatalf("OpenFile with O_EXCL should fail on existing file")
+	}
+	if _, err := mf.OpenFile("bar/foo.txt", os.O_RDWR|os.O_CREATE, 0600); err == nil {
+		t.Fatalf("OpenFile should not create file in missing directory")
+	}
+}
+
+func TestOpenFileTruncate(t *testing.T) {
+	mf := NewMockFilesystem()
+	f, _ := mf.Create("foo.txt")
+	f.Write([]byte("Hello world"))
+	f.Close()
+	if _, err := mf.OpenFile("foo.txt", os.O_WRONLY|os.O_TRUNC, 0); err != nil {
+		t.Fatalf("OpenFile should not return error: %v", err)
+	}
+	fi := ExpectFile(t, "/foo.txt", mf)
+	if fi.Size() != 0 {
+		t.Fatalf("File size %v, expected 0", fi.Size())
+	}
+}
+
+func TestOpenFileDirectory(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("foo", 0755)
+	if _, err := mf.OpenFile("foo", os.O_RDONLY, 0); err != nil {
+		t.Fatalf("Should be able to open directory for reading: %v", err)
+	}
+	if _, err := mf.OpenFile("foo", os.O_RDWR, 0); err == nil {
+		t.Fatalf("Should not be able to open directory for writing")
+	}
+	if _, err := mf.Create("foo"); err == nil {
+		t.Fatalf("Create should not replace directory")
+	}
+	ExpectDir(t, "/foo", mf)
+}
+
+func TestOpenFileAccessMode(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Create("foo.txt")
+	f, _ := mf.OpenFile("foo.txt", os.O_RDONLY, 0)
+	if _, err := f.Write([]byte("foo")); err == nil {
+		t.Fatalf("Write on read-only file should return error")
+	}
+	if _, err := f.WriteAt([]byte("foo"), 0); err == nil {
+		t.Fatalf("WriteAt on read-only file should return error")
+	}
+	if err := f.Truncate(0); err == nil {
+		t.Fatalf("Truncate on read-only file should return error")
+	}
+	f, _ = mf.OpenFile("foo.txt", os.O_WRONLY, 0)
+	if _, err := f.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("Read on write-only file should return error")
+	}
+	f, _ = mf.OpenFile("foo.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if _, err := f.WriteAt([]byte("foo"), 0); err != ErrAppendMode {
+		t.Fatalf("WriteAt on append-only file should return ErrAppendMode")
+	}
+}
+
+func ExpectContents(t *testing.T, expected string, path string, mf *MockFilesystem) {
+	fi := ExpectFile(t, path, mf)
+	if fi.Size() != int64(len(expected)) {
+		t.Fatalf("File size %v, expected %v", fi.Size(), len(expected))
+	}
+	ExpectEqual(t, expected, string(fi.buf))
+}
+
+func TestSequentialWrite(t *testing.T) {
+	mf := NewMockFilesystem()
+	f, _ := mf.Create("foo.txt")
+	f.Write([]byte("Hello"))
+	f.WriteString(" world")
+	ExpectContents(t, "Hello world", "foo.txt", mf)
+	if off, _ := f.Seek(0, 1); off != 11 {
+		t.Fatalf("Offset %v, expected 11", off)
+	}
+	f.Seek(0, 0)
+	f.Write([]byte("J"))
+	ExpectContents(t, "Jello world", "foo.txt", mf)
+}
+
+func TestWriteAppend(t *testing.T) {
+	mf := NewMockFilesystem()
+	f, _ := mf.Create("foo.txt")
+	f.Write([]byte("foo"))
+	f.Close()
+	f, _ = mf.OpenFile("foo.txt", os.O_WRONLY|os.O_APPEND, 0)
+	f.Seek(0, 0)
+	f.Write([]byte("bar"))
+	ExpectContents(t, "foobar", "foo.txt", mf)
+}
+
+func TestWritePastEnd(t *testing.T) {
+	mf := NewMockFilesystem()
+	f, _ := mf.Create("foo.txt")
+	f.Write([]byte("foo"))
+	f.Seek(2, 1)
+	f.Write([]byte("bar"))
+	ExpectContents(t, "foo\x00\x00bar", "foo.txt", mf)
+	f.WriteAt([]byte("baz"), 10)
+	ExpectContents(t, "foo\x00\x00bar\x00\x00baz", "foo.txt", mf)
+	if off, _ := f.Seek(0, 1); off != 8 {
+		t.Fatalf("WriteAt changed offset to %v, expected 8", off)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	mf := NewMockFilesystem()
+	f, _ := mf.Create("foo.txt")
+	f.Write([]byte("Hello world"))
+	if err := f.Truncate(5); err != nil {
+		t.Fatalf("Truncate should not return error: %v", err)
+	}
+	ExpectContents(t, "Hello", "foo.txt", mf)
+	if err := f.Truncate(8); err != nil {
+		t.Fatalf("Truncate should extend file: %v", err)
+	}
+	ExpectContents(t, "Hello\x00\x00\x00", "foo.txt", mf)
+	if off, _ := f.Seek(0, 1); off != 11 {
+		t.Fatalf("Truncate changed offset to %v, expected 11", off)
+	}
+	if err := f.Truncate(-1); err == nil {
+		t.Fatalf("Truncate should not accept negative size")
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	mf := NewMockFilesystem()
+	f, _ := mf.Create("foo.txt")
+	f.Write([]byte("Hello world"))
+	f.Seek(1, 0)
+	b := make([]byte, 5)
+	if n, err := f.ReadAt(b, 6); n != 5 || err != nil {
+		t.Fatalf("ReadAt returned %v, %v", n, err)
+	}
+	ExpectEqual(t, "world", string(b))
+	if n, err := f.ReadAt(b, 8); n != 3 || err != io.EOF {
+		t.Fatalf("ReadAt past end returned %v, %v", n, err)
+	}
+	n, _ := f.Read(b)
+	ExpectEqual(t, "ello ", string(b[:n]))
+}
+
+func TestReaddirPaginated(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("foo", 0755)
+	for i := 0; i < 5; i++ {
+		mf.Create(fmt.Sprintf("foo/%v.txt", i))
+	}
+	f, _ := mf.Open("foo")
+	names := []string{}
+	for {
+		batch, err := f.Readdirnames(2)
+		if err == io.EOF {
+			if len(batch) != 0 {
+				t.Fatalf("Readdirnames returned entries with io.EOF")
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("Readdirnames should not return error: %v", err)
+		}
+		if len(batch) == 0 || len(batch) > 2 {
+			t.Fatalf("Readdirnames returned %v entries", len(batch))
+		}
+		names = append(names, batch...)
+		if len(names) > 5 {
+			t.Fatalf("Readdirnames returned too many entries: %v", names)
+		}
+	}
+	ExpectEqual(t, "[0.txt 1.txt 2.txt 3.txt 4.txt]", fmt.Sprintf("%v", names))
+}
+
+func TestReaddirRemaining(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("foo", 0755)
+	mf.Create("foo/a.txt")
+	mf.Create("foo/b.txt")
+	mf.Create("foo/c.txt")
+	f, _ := mf.Open("foo")
+	f.Readdir(1)
+	fi, err := f.Readdir(-1)
+	if err != nil {
+		t.Fatalf("Readdir should not return error: %v", err)
+	}
+	ExpectEqual(t, "2", fmt.Sprintf("%v", len(fi)))
+	if fi, err = f.Readdir(-1); len(fi) != 0 || err != nil {
+		t.Fatalf("Readdir at end returned %v, %v", len(fi), err)
+	}
+	f.Seek(0, 0)
+	if fi, _ = f.Readdir(-1); len(fi) != 3 {
+		t.Fatalf("Seek should restart directory listing")
+	}
+}
+
+func TestReaddirFile(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Create("foo.txt")
+	f, _ := mf.Open("foo.txt")
+	if _, err := f.Readdir(-1); err == nil {
+		t.Fatalf("Readdir on file should return error")
+	}
+}
+
+func ExpectError(t *testing.T, expected error, err error) {
+	if !errors.Is(err, expected) {
+		t.Fatalf("Expected error '%v', got '%v'", expected, err)
+	}
+}
+
+func ExpectPathError(t *testing.T, op string, path string, expected error, err error) {
+	perr, ok := err.(*os.PathError)
+	if !ok {
+		t.Fatalf("Expected *os.PathError, got %#v", err)
+	}
+	if perr.Op != op || perr.Path != path {
+		t.Fatalf("Expected error for %v %v, got %v", op, path, err)
+	}
+	ExpectError(t, expected, err)
+}
+
+func TestErrNotExist(t *testing.T) {
+	mf := NewMockFilesystem()
+	_, err := mf.Open("foo.txt")
+	ExpectPathError(t, "open", "foo.txt", syscall.ENOENT, err)
+	if !os.IsNotExist(err) || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected error to satisfy os.IsNotExist: %v", err)
+	}
+	_, err = mf.Stat("/foo/bar")
+	ExpectPathError(t, "stat", "/foo/bar", fs.ErrNotExist, err)
+	ExpectPathError(t, "chdir", "foo", fs.ErrNotExist, mf.Chdir("foo"))
+	ExpectPathError(t, "remove", "foo", fs.ErrNotExist, mf.Remove("foo"))
+	ExpectPathError(t, "mkdir", "foo/bar", fs.ErrNotExist, mf.Mkdir("foo/bar", 0755))
+	err = mf.Rename("foo", "bar")
+	if _, ok := err.(*os.LinkError); !ok {
+		t.Fatalf("Expected *os.LinkError, got %#v", err)
+	}
+	ExpectError(t, fs.ErrNotExist, err)
+	if err = mf.RemoveAll("foo"); err != nil {
+		t.Fatalf("RemoveAll on missing path should not return error: %v", err)
+	}
+}
+
+func TestErrExist(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("foo", 0755)
+	mf.Create("foo.txt")
+	ExpectPathError(t, "mkdir", "foo", fs.ErrExist, mf.Mkdir("foo", 0755))
+	ExpectPathError(t, "mkdir", "foo.txt", syscall.EEXIST, mf.Mkdir("foo.txt", 0755))
+	_, err := mf.OpenFile("foo.txt", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	ExpectPathError(t, "open", "foo.txt", fs.ErrExist, err)
+	if !os.IsExist(err) {
+		t.Fatalf("Expected error to satisfy os.IsExist: %v", err)
+	}
+}
+
+func TestErrNotDir(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Create("foo.txt")
+	ExpectPathError(t, "chdir", "foo.txt", syscall.ENOTDIR, mf.Chdir("foo.txt"))
+	_, err := mf.Open("foo.txt/bar")
+	ExpectPathError(t, "open", "foo.txt/bar", syscall.ENOTDIR, err)
+	ExpectPathError(t, "mkdir", "foo.txt/bar", syscall.ENOTDIR, mf.Mkdir("foo.txt/bar", 0755))
+	err = mf.MkdirAll("foo.txt/bar", 0755)
+	ExpectPathError(t, "mkdir", "foo.txt", syscall.ENOTDIR, err)
+	f, _ := mf.Open("foo.txt")
+	_, err = f.Readdir(-1)
+	ExpectPathError(t, "readdirent", "foo.txt", syscall.ENOTDIR, err)
+}
+
+func TestErrIsDir(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("foo", 0755)
+	_, err := mf.OpenFile("foo", os.O_WRONLY, 0)
+	ExpectPathError(t, "open", "foo", syscall.EISDIR, err)
+	f, _ := mf.Open("foo")
+	_, err = f.Read(make([]byte, 1))
+	ExpectPathError(t, "read", "foo", syscall.EISDIR, err)
+}
+
+func TestErrNotEmpty(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.MkdirAll("foo/bar", 0755)
+	mf.Mkdir("baz", 0755)
+	ExpectPathError(t, "remove", "foo", errNotEmpty, mf.Remove("foo"))
+	ExpectError(t, errNotEmpty, mf.Rename("baz", "foo"))
+	ExpectError(t, syscall.EINVAL, mf.Rename("foo", "foo/bar/foo"))
+}
+
+func TestErrBadFile(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Create("foo.txt")
+	f, _ := mf.Open("foo.txt")
+	_, err := f.Write([]byte("foo"))
+	ExpectPathError(t, "write", "foo.txt", errBadFd, err)
+	f, _ = mf.OpenFile("foo.txt", os.O_WRONLY, 0)
+	_, err = f.Read(make([]byte, 1))
+	ExpectPathError(t, "read", "foo.txt", errBadFd, err)
+	_, err = f.Seek(0, 5)
+	ExpectPathError(t, "seek", "foo.txt", syscall.EINVAL, err)
+	ExpectPathError(t, "truncate", "foo.txt", syscall.EINVAL, f.Truncate(-1))
+	f.Close()
+	_, err = f.Write([]byte("foo"))
+	ExpectPathError(t, "write", "foo.txt", os.ErrClosed, err)
+	ExpectPathError(t, "close", "foo.txt", os.ErrClosed, f.Close())
+}
+
+func TestSymlink(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.MkdirAll("/releases/1", 0755)
+	f, _ := mf.Create("/releases/1/foo.txt")
+	f.Write([]byte("Hello world"))
+	if err := mf.Symlink("releases/1", "/current"); err != nil {
+		t.Fatalf("Symlink should not return error: %v", err)
+	}
+	fi, err := mf.Stat("/current/foo.txt")
+	if err != nil {
+		t.Fatalf("Stat through symlink should not return error: %v", err)
+	}
+	ExpectEqual(t, "/releases/1/foo.txt", fi.(*MockFileInfo).path())
+	if fi, err = mf.Lstat("/current"); err != nil {
+		t.Fatalf("Lstat should not return error: %v", err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Lstat mode %v, expected symlink", fi.Mode())
+	}
+	if fi, _ = mf.Stat("/current"); !fi.IsDir() {
+		t.Fatalf("Stat should follow symlink to directory")
+	}
+	target, err := mf.Readlink("/current")
+	if err != nil {
+		t.Fatalf("Readlink should not return error: %v", err)
+	}
+	ExpectEqual(t, "releases/1", target)
+	_, err = mf.Readlink("/releases")
+	ExpectPathError(t, "readlink", "/releases", syscall.EINVAL, err)
+	if _, ok := mf.Symlink("x", "/current").(*os.LinkError); !ok {
+		t.Fatalf("Symlink over existing path should return *os.LinkError")
+	}
+	ExpectError(t, fs.ErrExist, mf.Symlink("x", "/current"))
+}
+
+func TestSymlinkSwitch(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.MkdirAll("/releases/1", 0755)
+	mf.MkdirAll("/releases/2", 0755)
+	mf.Create("/releases/2/foo.txt")
+	mf.Symlink("releases/1", "/current")
+	mf.Symlink("releases/2", "/current.tmp")
+	if err := mf.Rename("/current.tmp", "/current"); err != nil {
+		t.Fatalf("Rename should replace symlink: %v", err)
+	}
+	ExpectFile(t, "/current/foo.txt", mf)
+	ExpectDir(t, "/releases/1", mf)
+	if err := mf.Remove("/current"); err != nil {
+		t.Fatalf("Remove should remove symlink: %v", err)
+	}
+	ExpectFile(t, "/releases/2/foo.txt", mf)
+}
+
+func TestSymlinkRelative(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.MkdirAll("/foo/bar", 0755)
+	mf.MkdirAll("/foo/baz", 0755)
+	mf.Create("/foo/baz/foo.txt")
+	mf.Symlink("../baz", "/foo/bar/link")
+	mf.Symlink("/foo/bar/link/foo.txt", "/abs")
+	ExpectFile(t, "/foo/bar/link/foo.txt", mf)
+	ExpectFile(t, "/abs", mf)
+	mf.Chdir("/foo/bar/link")
+	ExpectCwd(t, "/foo/baz", mf)
+	ExpectFile(t, "foo.txt", mf)
+}
+
+func TestSymlinkCreate(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("/foo", 0755)
+	mf.Symlink("foo/bar.txt", "/link")
+	f, err := mf.Create("/link")
+	if err != nil {
+		t.Fatalf("Create through dangling symlink should not return error: %v", err)
+	}
+	f.Write([]byte("Hello"))
+	ExpectContents(t, "Hello", "/foo/bar.txt", mf)
+	ExpectContents(t, "Hello", "/link", mf)
+}
+
+func TestSymlinkCreateExclusive(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Symlink("/target", "/link")
+	_, err := mf.OpenFile("/link", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	ExpectPathError(t, "open", "/link", syscall.EEXIST, err)
+	if _, err = mf.Lstat("/target"); !os.IsNotExist(err) {
+		t.Fatalf("O_EXCL should not create the target of a symlink: %v", err)
+	}
+}
+
+func TestSymlinkLoop(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Symlink("b", "/a")
+	mf.Symlink("a", "/b")
+	_, err := mf.Stat("/a")
+	ExpectPathError(t, "stat", "/a", errSymlinkLoop, err)
+	_, err = mf.Open("/a/foo.txt")
+	ExpectPathError(t, "open", "/a/foo.txt", errSymlinkLoop, err)
+	if _, err = mf.Lstat("/a"); err != nil {
+		t.Fatalf("Lstat should not follow symlink loop: %v", err)
+	}
+	mf.Symlink("self/x", "/self")
+	_, err = mf.Stat("/self")
+	ExpectError(t, errSymlinkLoop, err)
+}
+
+func TestLink(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("foo", 0755)
+	f, _ := mf.Create("foo/a.txt")
+	f.Write([]byte("Hello"))
+	f.Close()
+	if err := mf.Link("foo/a.txt", "b.txt"); err != nil {
+		t.Fatalf("Link should not return error: %v", err)
+	}
+	fi, _ := mf.Stat("b.txt")
+	ExpectEqual(t, "b.txt", fi.Name())
+	ExpectNlink(t, 2, fi)
+	f, _ = mf.OpenFile("b.txt", os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte(" world"))
+	f.Close()
+	ExpectContents(t, "Hello world", "foo/a.txt", mf)
+	if err := mf.Remove("foo/a.txt"); err != nil {
+		t.Fatalf("Remove should not return error: %v", err)
+	}
+	ExpectContents(t, "Hello world", "b.txt", mf)
+	fi, _ = mf.Stat("b.txt")
+	ExpectNlink(t, 1, fi)
+}
+
+func TestLinkErrors(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("foo", 0755)
+	mf.Create("a.txt")
+	ExpectError(t, syscall.EPERM, mf.Link("foo", "bar"))
+	ExpectError(t, fs.ErrExist, mf.Link("a.txt", "foo"))
+	ExpectError(t, fs.ErrNotExist, mf.Link("b.txt", "c.txt"))
+	if _, ok := mf.Link("b.txt", "c.txt").(*os.LinkError); !ok {
+		t.Fatalf("Link should return *os.LinkError")
+	}
+}
+
+func TestLinkRemoveDiscardsData(t *testing.T) {
+	mf := NewMockFilesystem()
+	f, _ := mf.Create("a.txt")
+	f.Write([]byte("Hello"))
+	mf.Link("a.txt", "b.txt")
+	fi := ExpectFile(t, "a.txt", mf)
+	mf.Remove("a.txt")
+	mf.Remove("b.txt")
+	b := make([]byte, 5)
+	if n, _ := f.ReadAt(b, 0); n != 5 {
+		t.Fatalf("Open file should remain readable after last link is removed")
+	}
+	f.Close()
+	if fi.buf != nil {
+		t.Fatalf("Data should be discarded once the last link is closed")
+	}
+}
+
+func TestLinkRenameOverSelf(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Create("a.txt")
+	mf.Link("a.txt", "b.txt")
+	if err := mf.Rename("a.txt", "b.txt"); err != nil {
+		t.Fatalf("Rename between links should not return error: %v", err)
+	}
+	ExpectFile(t, "a.txt", mf)
+	fi := ExpectFile(t, "b.txt", mf)
+	ExpectNlink(t, 2, fi)
+}
+
+func TestDirectoryNlink(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.MkdirAll("foo/a", 0755)
+	mf.Mkdir("foo/b", 0755)
+	mf.Create("foo/c.txt")
+	fi, _ := mf.Stat("foo")
+	ExpectNlink(t, 4, fi)
+	mf.Rename("foo/b", "b")
+	mf.RemoveAll("foo/a")
+	fi, _ = mf.Stat("foo")
+	ExpectNlink(t, 2, fi)
+	fi, _ = mf.Stat("/")
+	ExpectNlink(t, 4, fi)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	var wg sync.WaitGroup
+	mf := NewMockFilesystem()
+	mf.Mkdir("/shared", 0755)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			dir := fmt.Sprintf("/worker%v", i)
+			for j := 0; j < 50; j++ {
+				mf.MkdirAll(dir, 0755)
+				f, err := mf.Create(fmt.Sprintf("%v/%v.txt", dir, j))
+				if err == nil {
+					f.Write([]byte("Hello world"))
+					f.Close()
+				}
+				if d, err := mf.Open("/"); err == nil {
+					if fi, err := d.Readdir(-1); err == nil {
+						for _, info := range fi {
+							info.Size()
+							info.ModTime()
+						}
+					}
+					d.Close()
+				}
+				if f, err = mf.OpenFile("/shared/log.txt", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err == nil {
+					f.Write([]byte("0123456789"))
+					f.Close()
+				}
+				if j%10 == 0 {
+					mf.RemoveAll(dir)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	fi, err := mf.Stat("/shared/log.txt")
+	if err != nil {
+		t.Fatalf("Stat should not return error: %v", err)
+	}
+	if fi.Size() != 8*50*10 {
+		t.Fatalf("File size %v, expected %v", fi.Size(), 8*50*10)
+	}
+}
+
+func TestConcurrentFileAccess(t *testing.T) {
+	var wg sync.WaitGroup
+	mf := NewMockFilesystem()
+	f, _ := mf.Create("/foo.txt")
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := make([]byte, 10)
+			for j := 0; j < 100; j++ {
+				f.Write([]byte("0123456789"))
+				f.ReadAt(b, 0)
+				f.Stat()
+			}
+		}()
+	}
+	wg.Wait()
+	ExpectContents(t, strings.Repeat("0123456789", 800), "/foo.txt", mf)
+}
+
+func ExpectModTime(t *testing.T, expected time.Time, path string, mf *MockFilesystem) {
+	fi, err := mf.Lstat(path)
+	if err != nil {
+		t.Fatalf("Lstat should not return error: %v", err)
+	}
+	if !fi.ModTime().Equal(expected) {
+		t.Fatalf("Modification time of %v is %v, expected %v", path, fi.ModTime(), expected)
+	}
+}
+
+func TestClockTimestamps(t *testing.T) {
+	start := time.Date(2012, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	mf := NewMockFilesystem(WithClock(clock))
+	ExpectModTime(t, start, "/", mf)
+	clock.Advance(time.Minute)
+	mf.Mkdir("/foo", 0755)
+	ExpectModTime(t, start.Add(time.Minute), "/foo", mf)
+	ExpectModTime(t, start.Add(time.Minute), "/", mf)
+	clock.Advance(time.Minute)
+	mf.Create("/foo/a.txt")
+	mf.Symlink("a.txt", "/foo/b.txt")
+	ExpectModTime(t, start.Add(2*time.Minute), "/foo/a.txt", mf)
+	ExpectModTime(t, start.Add(2*time.Minute), "/foo/b.txt", mf)
+	ExpectModTime(t, start.Add(2*time.Minute), "/foo", mf)
+	ExpectModTime(t, start.Add(time.Minute), "/", mf)
+	clock.Advance(time.Minute)
+	mf.Remove("/foo/b.txt")
+	ExpectModTime(t, start.Add(3*time.Minute), "/foo", mf)
+	clock.Advance(time.Minute)
+	mf.RemoveAll("/foo")
+	ExpectModTime(t, start.Add(4*time.Minute), "/", mf)
+}
+
+func TestClockNewerThan(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	mf := NewMockFilesystem(WithClock(clock))
+	mf.Create("source.txt")
+	clock.Advance(time.Second)
+	mf.Create("target.txt")
+	source, _ := mf.Stat("source.txt")
+	target, _ := mf.Stat("target.txt")
+	if !target.ModTime().After(source.ModTime()) {
+		t.Fatalf("Target should be newer than source")
+	}
+	clock.Advance(time.Second)
+	mf.OpenFile("source.txt", os.O_WRONLY|os.O_TRUNC, 0)
+	source, _ = mf.Stat("source.txt")
+	if !source.ModTime().After(target.ModTime()) {
+		t.Fatalf("Source should be newer than target after truncation")
+	}
+}
+
+func ExpectTimes(t *testing.T, atime time.Time, mtime time.Time, ctime time.Time, path string, mf *MockFilesystem) {
+	fi := ExpectFile(t, path, mf)
+	if !fi.atime.Equal(atime) || !fi.mtime.Equal(mtime) || !fi.ctime.Equal(ctime) {
+		t.Fatalf("Times of %v are %v, %v, %v, expected %v, %v, %v",
+			path, fi.atime, fi.mtime, fi.ctime, atime, mtime, ctime)
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	t1, t2, t3, t4 := t0.Add(time.Second), t0.Add(2*time.Second), t0.Add(3*time.Second), t0.Add(4*time.Second)
+	clock := NewFakeClock(t0)
+	mf := NewMockFilesystem(WithClock(clock))
+	f, _ := mf.Create("foo.txt")
+	ExpectTimes(t, t0, t0, t0, "foo.txt", mf)
+	clock.Set(t1)
+	f.Write([]byte("Hello"))
+	ExpectTimes(t, t0, t1, t1, "foo.txt", mf)
+	clock.Set(t2)
+	f.ReadAt(make([]byte, 5), 0)
+	ExpectTimes(t, t2, t1, t1, "foo.txt", mf)
+	clock.Set(t3)
+	f.Chmod(0600)
+	ExpectTimes(t, t2, t1, t3, "foo.txt", mf)
+	clock.Set(t4)
+	f.Truncate(2)
+	ExpectTimes(t, t2, t4, t4, "foo.txt", mf)
+}
+
+func TestTimestampsRename(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	clock := NewFakeClock(t0)
+	mf := NewMockFilesystem(WithClock(clock))
+	mf.Mkdir("foo", 0755)
+	mf.Create("foo/a.txt")
+	clock.Advance(time.Second)
+	mf.Rename("foo/a.txt", "b.txt")
+	ExpectTimes(t, t0, t0, t0.Add(time.Second), "b.txt", mf)
+	ExpectTimes(t, t0, t0.Add(time.Second), t0.Add(time.Second), "foo", mf)
+	clock.Advance(time.Second)
+	d, _ := mf.Open("foo")
+	d.Readdir(-1)
+	ExpectTimes(t, t0.Add(2*time.Second), t0.Add(time.Second), t0.Add(time.Second), "foo", mf)
+}
+
+func TestChtimes(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	clock := NewFakeClock(t0)
+	mf := NewMockFilesystem(WithClock(clock))
+	mf.Create("foo.txt")
+	mf.Symlink("foo.txt", "link")
+	clock.Advance(time.Hour)
+	atime, mtime := time.Unix(100, 0), time.Unix(200, 0)
+	if err := mf.Chtimes("link", atime, mtime); err != nil {
+		t.Fatalf("Chtimes should not return error: %v", err)
+	}
+	ExpectTimes(t, atime, mtime, t0.Add(time.Hour), "foo.txt", mf)
+	mf.Chtimes("foo.txt", time.Time{}, t0)
+	ExpectTimes(t, atime, t0, t0.Add(time.Hour), "foo.txt", mf)
+	ExpectPathError(t, "chtimes", "bar.txt", fs.ErrNotExist, mf.Chtimes("bar.txt", atime, mtime))
+}
+
+func ExpectPerm(t *testing.T, expected os.FileMode, path string, mf *MockFilesystem) {
+	fi, err := mf.Lstat(path)
+	if err != nil {
+		t.Fatalf("Could not stat %v: %v", path, err)
+	}
+	if perm := fi.Mode() &^ os.ModeType; perm != expected {
+		t.Fatalf("Perm of %v is %v, expected %v", path, perm, expected)
+	}
+}
+
+func TestUmask(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("a", 0777)
+	mf.MkdirAll("b/c", 0777)
+	mf.OpenFile("d.txt", os.O_RDWR|os.O_CREATE, 0666)
+	mf.Symlink("d.txt", "e")
+	ExpectPerm(t, 0755, "a", mf)
+	ExpectPerm(t, 0755, "b", mf)
+	ExpectPerm(t, 0755, "b/c", mf)
+	ExpectPerm(t, 0644, "d.txt", mf)
+	ExpectPerm(t, 0777, "e", mf)
+	if old := mf.Umask(077); old != 022 {
+		t.Fatalf("Umask returned %v, expected 022", old)
+	}
+	mf.Mkdir("f", 0777)
+	mf.Create("g.txt")
+	ExpectPerm(t, 0700, "f", mf)
+	ExpectPerm(t, 0600, "g.txt", mf)
+	mf.Umask(0)
+	mf.Mkdir("h", 0777|os.ModeSticky)
+	ExpectPerm(t, 0777|os.ModeSticky, "h", mf)
+}
+
+func TestGetwd(t *testing.T) {
+	mf := NewMockFilesystem()
+	if dir, err := mf.Getwd(); err != nil || dir != "/" {
+		t.Fatalf("Getwd returned %v, %v, expected /", dir, err)
+	}
+	mf.MkdirAll("foo/bar", 0755)
+	mf.Chdir("foo/bar")
+	if dir, err := mf.Getwd(); err != nil || dir != "/foo/bar" {
+		t.Fatalf("Getwd returned %v, %v, expected /foo/bar", dir, err)
+	}
+	mf.Rename("/foo", "/baz")
+	if dir, _ := mf.Getwd(); dir != "/baz/bar" {
+		t.Fatalf("Getwd returned %v, expected /baz/bar", dir)
+	}
+	mf.Remove("/baz/bar")
+	_, err := mf.Getwd()
+	ExpectError(t, syscall.ENOENT, err)
+}
+
+func TestChmod(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("foo", 0755)
+	mf.Symlink("foo", "bar")
+	if err := mf.Chmod("bar", 0700); err != nil {
+		t.Fatalf("Chmod returned error: %v", err)
+	}
+	ExpectPerm(t, 0700, "foo", mf)
+	ExpectDir(t, "foo", mf)
+	ExpectPerm(t, 0777, "bar", mf)
+	ExpectPathError(t, "chmod", "baz", fs.ErrNotExist, mf.Chmod("baz", 0700))
+}
+
+func TestTruncateName(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.WriteFile("foo.txt", []byte("hello"), 0644)
+	if err := mf.Truncate("foo.txt", 2); err != nil {
+		t.Fatalf("Truncate returned error: %v", err)
+	}
+	ExpectContents(t, "he", "foo.txt", mf)
+	mf.Truncate("foo.txt", 4)
+	ExpectContents(t, "he\x00\x00", "foo.txt", mf)
+	mf.Mkdir("bar", 0755)
+	ExpectPathError(t, "truncate", "bar", syscall.EISDIR, mf.Truncate("bar", 0))
+	ExpectPathError(t, "truncate", "foo.txt", syscall.EINVAL, mf.Truncate("foo.txt", -1))
+	ExpectPathError(t, "truncate", "baz", fs.ErrNotExist, mf.Truncate("baz", 0))
+}
+
+func TestReadDir(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("foo", 0755)
+	mf.Create("foo/b.txt")
+	mf.Mkdir("foo/a", 0755)
+	entries, err := mf.ReadDir("foo")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("ReadDir returned %v, %v", entries, err)
+	}
+	if entries[0].Name() != "a" || !entries[0].IsDir() {
+		t.Fatalf("Expected directory a first, got %v", entries[0])
+	}
+	if entries[1].Name() != "b.txt" || entries[1].Type() != 0 {
+		t.Fatalf("Expected regular file b.txt second, got %v", entries[1])
+	}
+	_, err = mf.ReadDir("foo/b.txt")
+	ExpectPathError(t, "readdirent", "foo/b.txt", syscall.ENOTDIR, err)
+}
+
+func TestReadWriteFile(t *testing.T) {
+	mf := NewMockFilesystem()
+	if err := mf.WriteFile("foo.txt", []byte("hello world"), 0600); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	ExpectPerm(t, 0600, "foo.txt", mf)
+	mf.WriteFile("foo.txt", []byte("bye"), 0644)
+	data, err := mf.ReadFile("foo.txt")
+	if err != nil || string(data) != "bye" {
+		t.Fatalf("ReadFile returned %q, %v, expected bye", data, err)
+	}
+	ExpectPerm(t, 0600, "foo.txt", mf)
+	_, err = mf.ReadFile("bar.txt")
+	ExpectPathError(t, "open", "bar.txt", fs.ErrNotExist, err)
+	mf.Mkdir("baz", 0755)
+	_, err = mf.ReadFile("baz")
+	ExpectPathError(t, "read", "baz", syscall.EISDIR, err)
+}
+
+func TestMkdirTemp(t *testing.T) {
+	mf := NewMockFilesystem()
+	_, err := mf.MkdirTemp("", "foo")
+	ExpectPathError(t, "mkdir", "/tmp/foo1", fs.ErrNotExist, err)
+	mf.Mkdir(mf.TempDir(), 0777)
+	mf.Mkdir("/tmp/foo2", 0755)
+	name, err := mf.MkdirTemp("", "foo")
+	if err != nil || name != "/tmp/foo3" {
+		t.Fatalf("MkdirTemp returned %v, %v, expected /tmp/foo3", name, err)
+	}
+	ExpectPerm(t, 0700, name, mf)
+	if name, _ = mf.MkdirTemp("/tmp/", "a*b*c"); name != "/tmp/a*b4c" {
+		t.Fatalf("MkdirTemp returned %v, expected /tmp/a*b4c", name)
+	}
+	_, err = mf.MkdirTemp("", "a/b")
+	ExpectPathError(t, "mkdirtemp", "a/b", errPatternHasSeparator, err)
+}
+
+func TestCreateTemp(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("foo", 0755)
+	mf.Create("foo/1.txt")
+	f, err := mf.CreateTemp("foo", "*.txt")
+	if err != nil || f.Name() != "foo/2.txt" {
+		t.Fatalf("CreateTemp returned %v, %v, expected foo/2.txt", f, err)
+	}
+	defer f.Close()
+	f.WriteString("hello")
+	ExpectContents(t, "hello", "foo/2.txt", mf)
+	ExpectPerm(t, 0600, "foo/2.txt", mf)
+	_, err = mf.CreateTemp("foo", "a/*")
+	ExpectPathError(t, "createtemp", "a/*", errPatternHasSeparator, err)
+}
+
+func TestFileReadDir(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("foo", 0755)
+	mf.Create("foo/a.txt")
+	mf.Mkdir("foo/b", 0755)
+	mf.Create("foo/c.txt")
+	f, _ := mf.Open("foo")
+	defer f.Close()
+	entries, err := f.ReadDir(2)
+	if err != nil || len(entries) != 2 || entries[0].Name() != "a.txt" || !entries[1].IsDir() {
+		t.Fatalf("ReadDir returned %v, %v", entries, err)
+	}
+	entries, err = f.ReadDir(2)
+	if err != nil || len(entries) != 1 || entries[0].Name() != "c.txt" {
+		t.Fatalf("ReadDir returned %v, %v", entries, err)
+	}
+	_, err = f.ReadDir(2)
+	ExpectError(t, io.EOF, err)
+}
+
+func TestReadFromWriteTo(t *testing.T) {
+	mf := NewMockFilesystem()
+	f, _ := mf.Create("foo.txt")
+	defer f.Close()
+	var _ io.ReaderFrom = f
+	var _ io.WriterTo = f
+	n, err := f.ReadFrom(strings.NewReader("hello world"))
+	if err != nil || n != 11 {
+		t.Fatalf("ReadFrom returned %v, %v", n, err)
+	}
+	ExpectContents(t, "hello world", "foo.txt", mf)
+	f.Seek(6, io.SeekStart)
+	var sb strings.Builder
+	if n, err = f.WriteTo(&sb); err != nil || n != 5 || sb.String() != "world" {
+		t.Fatalf("WriteTo returned %v, %v, %q", n, err, sb.String())
+	}
+	g, _ := mf.Create("bar.txt")
+	defer g.Close()
+	f.Seek(0, io.SeekStart)
+	if n, err = io.Copy(g, f); err != nil || n != 11 {
+		t.Fatalf("io.Copy between mock files returned %v, %v", n, err)
+	}
+	ExpectContents(t, "hello world", "bar.txt", mf)
+}
+
+func TestFd(t *testing.T) {
+	mf := NewMockFilesystem()
+	f, _ := mf.Create("foo.txt")
+	g, _ := mf.Open("foo.txt")
+	if f.Fd() != 1<<31+1 || g.Fd() != 1<<31+2 {
+		t.Fatalf("Expected descriptors 1<<31+1 and 1<<31+2, got %v and %v", f.Fd(), g.Fd())
+	}
+	g.Close()
+	if g.Fd() != ^uintptr(0) {
+		t.Fatalf("Expected invalid descriptor after close, got %v", g.Fd())
+	}
+	conn, err := f.SyscallConn()
+	if conn != nil {
+		t.Fatalf("SyscallConn should not return a RawConn for a fake descriptor")
+	}
+	ExpectPathError(t, "syscallconn", "foo.txt", errors.ErrUnsupported, err)
+}
+
+func TestDeadline(t *testing.T) {
+	mf := NewMockFilesystem()
+	f, _ := mf.Create("foo.txt")
+	ExpectError(t, os.ErrNoDeadline, f.SetDeadline(time.Now()))
+	ExpectError(t, os.ErrNoDeadline, f.SetReadDeadline(time.Now()))
+	ExpectError(t, os.ErrNoDeadline, f.SetWriteDeadline(time.Now()))
+	f.Close()
+	ExpectPathError(t, "SetDeadline", "foo.txt", os.ErrClosed, f.SetDeadline(time.Now()))
+}
+
+func TestSameFile(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Create("a.txt")
+	mf.Create("b.txt")
+	mf.Link("a.txt", "c.txt")
+	mf.Symlink("a.txt", "d.txt")
+	a, _ := mf.Stat("a.txt")
+	b, _ := mf.Stat("b.txt")
+	c, _ := mf.Stat("c.txt")
+	d, _ := mf.Stat("d.txt")
+	if !SameFile(a, c) || !SameFile(a, d) {
+		t.Fatalf("Links should be the same file")
+	}
+	if SameFile(a, b) {
+		t.Fatalf("Different files should not be the same file")
+	}
+	mf.Rename("a.txt", "e.txt")
+	e, _ := mf.Stat("e.txt")
+	if !SameFile(a, e) {
+		t.Fatalf("Inode number should be stable across rename")
+	}
+	other := NewMockFilesystem()
+	other.Create("a.txt")
+	o, _ := other.Stat("a.txt")
+	if SameFile(a, o) {
+		t.Fatalf("Files in different filesystems should not be the same file")
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"os"
+	"syscall"
+)
+
+// Permission bits, shifted down to the "other" position.
+const (
+	permRead  os.FileMode = 04
+	permWrite os.FileMode = 02
+	permExec  os.FileMode = 01
+)
+
+// The identity a MockFilesystem performs operations as, like the
+// effective user and group IDs and supplementary groups of a process.
+type Credentials struct {
+	Uid    int
+	Gid    int
+	Groups []int
+}
+
+// Makes the filesystem check permission bits on every operation as if it
+// were performed by the user described by creds.  The root directory and
+// files created by the filesystem are owned by that user.  A Uid of 0
+// bypasses the checks, as root does.
+func WithCredentials(creds Credentials) MockOption {
+	creds.Groups = append([]int(nil), creds.Groups...)
+	return func(mf *MockFilesystem) {
+		mf.creds = &creds
+	}
+}
+
+// Changes the user which operations are performed as.  Passing nil turns
+// off permission checks.
+func (mf *MockFilesystem) SetCredentials(creds *Credentials) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	if creds == nil {
+		mf.creds = nil
+		return
+	}
+	copied := *creds
+	copied.Groups = append([]int(nil), creds.Groups...)
+	mf.creds = &copied
+}
+
+// Returns the owner for newly created nodes.
+func (mf *MockFilesystem) owner() (uid int, gid int) {
+	if mf.creds == nil {
+		return 0, 0
+	}
+	return mf.creds.Uid, mf.creds.Gid
+}
+
+func (mf *MockFilesystem) inGroup(gid int) bool {
+	if mf.creds.Gid == gid {
+		return true
+	}
+	for _, group := range mf.creds.Groups {
+		if group == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the current user owns fi or is root, which allows
+// changing its metadata.
+func (mf *MockFilesystem) isOwner(fi *MockFileInfo) bool {
+	return mf.creds == nil || mf.creds.Uid == 0 || mf.creds.Uid == fi.uid
+}
+
+// Returns EACCES unless the current user has each of the permissions in
+// want, a combination of permRead, permWrite and permExec, on fi.
+func (mf *MockFilesystem) access(fi *MockFileInfo, want os.FileMode) error {
+	if mf.creds == nil || mf.creds.Uid == 0 {
+		return nil
+	}
+	perm := fi.mode.Perm()
+	switch {
+	case mf.creds.Uid == fi.uid:
+		perm >>= 6
+	case mf.inGroup(fi.gid):
+		perm >>= 3
+	}
+	if perm&want != want {
+		return syscall.EACCES
+	}
+	return nil
+}
+
+// Checks that the current user may unlink fi from its directory, honoring
+// the sticky bit.
+func (mf *MockFilesystem) mayDelete(fi *MockFileInfo) error {
+	dir := fi.Parent()
+	if err := mf.access(dir, permWrite|permExec); err != nil {
+		return err
+	}
+	if dir.mode&os.ModeSticky != 0 && !mf.isOwner(fi) && !mf.isOwner(dir) {
+		return syscall.EPERM
+	}
+	return nil
+}
+
+// Checks that the current user may move src into dir, replacing dst if it
+// is not nil.
+func (mf *MockFilesystem) mayRename(src *MockFileInfo, dir *MockFileInfo, dst *MockFileInfo) error {
+	if err := mf.mayDelete(src); err != nil {
+		return err
+	}
+	if err := mf.access(dir, permWrite|permExec); err != nil {
+		return err
+	}
+	if dst != nil {
+		if err := mf.mayDelete(dst); err != nil {
+			return err
+		}
+	}
+	if src.IsDir() && src.Parent() != dir {
+		// Moving a directory rewrites its ".." entry.
+		return mf.access(src, permWrite)
+	}
+	return nil
+}
+
+// Checks that the current user may remove fi and everything below it.
+func (mf *MockFilesystem) mayDeleteAll(fi *MockFileInfo) error {
+	if len(fi.children) > 0 {
+		if err := mf.access(fi, permRead|permWrite|permExec); err != nil {
+			return err
+		}
+		for _, child := range fi.children {
+			if err := mf.mayDeleteAll(child); err != nil {
+				return err
+			}
+		}
+	}
+	return mf.mayDelete(fi)
+}
+
+// Changes the owner and group of fi.  A uid or gid of -1 is left
+// unchanged.  Only root may give a file away, and the owner may only
+// change the group to one they belong to.
+func (mf *MockFilesystem) chown(fi *MockFileInfo, uid int, gid int) error {
+	if uid == -1 {
+		uid = fi.uid
+	}
+	if gid == -1 {
+		gid = fi.gid
+	}
+	if mf.creds != nil && mf.creds.Uid != 0 {
+		if mf.creds.Uid != fi.uid || uid != fi.uid {
+			return syscall.EPERM
+		}
+		if gid != fi.gid && !mf.inGroup(gid) {
+			return syscall.EPERM
+		}
+	}
+	fi.uid = uid
+	fi.gid = gid
+	if fi.mode.IsRegular() {
+		fi.mode &^= os.ModeSetuid | os.ModeSetgid
+	}
+	fi.changed(mf.clock.Now())
+	return nil
+}
+
+// Changes the permission bits of fi, keeping its type.  Only the owner
+// may do so.
+func (mf *MockFilesystem) chmod(fi *MockFileInfo, mode os.FileMode) error {
+	if !mf.isOwner(fi) {
+		return syscall.EPERM
+	}
+	mode &= os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	fi.mode = fi.mode&os.ModeType | mode
+	fi.changed(mf.clock.Now())
+	return nil
+}
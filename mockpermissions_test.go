@@ -0,0 +1,279 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func chmod(t *testing.T, mf *MockFilesystem, name string, mode os.FileMode) {
+	f, err := mf.Open(name)
+	if err != nil {
+		t.Fatalf("Could not open %v: %v", name, err)
+	}
+	defer f.Close()
+	if err = f.Chmod(mode); err != nil {
+		t.Fatalf("Could not chmod %v: %v", name, err)
+	}
+}
+
+func TestCredentialsOwnNewFiles(t *testing.T) {
+	mf := NewMockFilesystem(WithCredentials(Credentials{Uid: 1000, Gid: 100}))
+	mf.Create("foo.txt")
+	fi := ExpectFile(t, "foo.txt", mf)
+	if fi.uid != 1000 || fi.gid != 100 {
+		t.Fatalf("Expected owner 1000:100, got %v:%v", fi.uid, fi.gid)
+	}
+}
+
+func TestCredentialsAreCopied(t *testing.T) {
+	groups := []int{200}
+	mf := NewMockFilesystem(WithCredentials(Credentials{Uid: 1000, Gid: 100, Groups: groups}))
+	groups[0] = 0
+	if mf.inGroup(0) || !mf.inGroup(200) {
+		t.Fatalf("WithCredentials kept the caller's groups, got %v", mf.creds.Groups)
+	}
+	creds := &Credentials{Uid: 1000, Gid: 100, Groups: groups}
+	mf.SetCredentials(creds)
+	groups[0] = 300
+	creds.Uid = 0
+	if mf.inGroup(300) || !mf.inGroup(0) || mf.creds.Uid != 1000 {
+		t.Fatalf("SetCredentials kept the caller's credentials, got %+v", *mf.creds)
+	}
+}
+
+func TestPermissionOpen(t *testing.T) {
+	mf := NewMockFilesystem()
+	f, _ := mf.OpenFile("secret.txt", os.O_RDWR|os.O_CREATE, 0600)
+	f.Close()
+	f, _ = mf.OpenFile("shared.txt", os.O_RDWR|os.O_CREATE, 0640)
+	f.Close()
+	mf.SetCredentials(&Credentials{Uid: 1000, Gid: 100, Groups: []int{0}})
+	_, err := mf.Open("secret.txt")
+	ExpectPathError(t, "open", "secret.txt", fs.ErrPermission, err)
+	ExpectError(t, syscall.EACCES, err)
+	if f, err = mf.Open("shared.txt"); err != nil {
+		t.Fatalf("Supplementary group should grant read: %v", err)
+	}
+	f.Close()
+	_, err = mf.OpenFile("shared.txt", os.O_WRONLY, 0)
+	ExpectPathError(t, "open", "shared.txt", syscall.EACCES, err)
+	_, err = mf.OpenFile("shared.txt", os.O_RDONLY|os.O_TRUNC, 0)
+	ExpectPathError(t, "open", "shared.txt", syscall.EACCES, err)
+	mf.SetCredentials(nil)
+	if f, err = mf.OpenFile("secret.txt", os.O_RDWR, 0); err != nil {
+		t.Fatalf("Checks should be disabled without credentials: %v", err)
+	}
+	f.Close()
+}
+
+func TestPermissionOwnerBitsOnly(t *testing.T) {
+	mf := NewMockFilesystem(WithCredentials(Credentials{Uid: 1000, Gid: 100}))
+	f, _ := mf.OpenFile("foo.txt", os.O_RDWR|os.O_CREATE, 0077)
+	f.Close()
+	_, err := mf.Open("foo.txt")
+	ExpectPathError(t, "open", "foo.txt", syscall.EACCES, err)
+}
+
+func TestPermissionRootBypasses(t *testing.T) {
+	mf := NewMockFilesystem(WithCredentials(Credentials{Uid: 1000, Gid: 100}))
+	mf.Mkdir("foo", 0)
+	f, _ := mf.OpenFile("foo.txt", os.O_RDWR|os.O_CREATE, 0)
+	f.Close()
+	mf.SetCredentials(&Credentials{Uid: 0, Gid: 0})
+	f, err := mf.OpenFile("foo.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Root should bypass permission bits: %v", err)
+	}
+	f.Close()
+	if err = mf.Mkdir("foo/bar", 0755); err != nil {
+		t.Fatalf("Root should bypass permission bits: %v", err)
+	}
+}
+
+func TestPermissionTraverse(t *testing.T) {
+	mf := NewMockFilesystem(WithCredentials(Credentials{Uid: 1000, Gid: 100}))
+	mf.MkdirAll("foo/bar", 0755)
+	mf.Create("foo/bar/baz.txt")
+	f, _ := mf.Open("foo")
+	chmod(t, mf, "foo", 0644)
+	_, err := mf.Stat("foo/bar/baz.txt")
+	ExpectPathError(t, "stat", "foo/bar/baz.txt", syscall.EACCES, err)
+	ExpectPathError(t, "chdir", "foo", syscall.EACCES, mf.Chdir("foo"))
+	ExpectPathError(t, "chdir", "foo", syscall.EACCES, f.Chdir())
+	ExpectPathError(t, "mkdir", "foo/qux", syscall.EACCES, mf.Mkdir("foo/qux", 0755))
+	if _, err = mf.Stat("foo"); err != nil {
+		t.Fatalf("Stat of the directory itself should succeed: %v", err)
+	}
+	names, err := f.Readdirnames(-1)
+	if err != nil || len(names) != 1 {
+		t.Fatalf("Listing a readable directory should succeed: %v %v", names, err)
+	}
+	f.Close()
+}
+
+func TestPermissionCreate(t *testing.T) {
+	mf := NewMockFilesystem(WithCredentials(Credentials{Uid: 1000, Gid: 100}))
+	mf.Mkdir("foo", 0555)
+	mf.Create("bar.txt")
+	_, err := mf.Create("foo/a.txt")
+	ExpectPathError(t, "open", "foo/a.txt", syscall.EACCES, err)
+	ExpectPathError(t, "mkdir", "foo/b", syscall.EACCES, mf.Mkdir("foo/b", 0755))
+	ExpectError(t, syscall.EACCES, mf.Symlink("bar.txt", "foo/c"))
+	ExpectError(t, syscall.EACCES, mf.Link("bar.txt", "foo/d"))
+	ExpectError(t, syscall.EACCES, mf.Rename("bar.txt", "foo/e"))
+	ExpectPathError(t, "mkdir", "foo", syscall.EEXIST, mf.Mkdir("foo", 0755))
+	if len(ExpectFile(t, "foo", mf).Children()) != 0 {
+		t.Fatalf("No entries should have been created")
+	}
+}
+
+func TestPermissionRemove(t *testing.T) {
+	mf := NewMockFilesystem(WithCredentials(Credentials{Uid: 1000, Gid: 100}))
+	mf.MkdirAll("foo/bar", 0755)
+	mf.Create("foo/a.txt")
+	mf.Create("foo/bar/b.txt")
+	chmod(t, mf, "foo/bar", 0555)
+	ExpectPathError(t, "remove", "foo/bar/b.txt", syscall.EACCES, mf.Remove("foo/bar/b.txt"))
+	ExpectPathError(t, "unlinkat", "foo", syscall.EACCES, mf.RemoveAll("foo"))
+	ExpectFile(t, "foo/a.txt", mf)
+	ExpectFile(t, "foo/bar/b.txt", mf)
+	chmod(t, mf, "foo/bar", 0755)
+	if err := mf.RemoveAll("foo"); err != nil {
+		t.Fatalf("RemoveAll should succeed: %v", err)
+	}
+}
+
+func TestPermissionSticky(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Umask(0)
+	mf.Mkdir("tmp", 0777|os.ModeSticky)
+	mf.SetCredentials(&Credentials{Uid: 1000, Gid: 100})
+	mf.Create("tmp/mine.txt")
+	mf.SetCredentials(&Credentials{Uid: 1001, Gid: 100})
+	mf.Create("tmp/theirs.txt")
+	ExpectPathError(t, "remove", "tmp/mine.txt", syscall.EPERM, mf.Remove("tmp/mine.txt"))
+	ExpectError(t, syscall.EPERM, mf.Rename("tmp/mine.txt", "tmp/stolen.txt"))
+	ExpectError(t, syscall.EPERM, mf.Rename("tmp/theirs.txt", "tmp/mine.txt"))
+	if err := mf.Remove("tmp/theirs.txt"); err != nil {
+		t.Fatalf("Owner should be able to remove from sticky directory: %v", err)
+	}
+	mf.SetCredentials(nil)
+	if err := mf.Remove("tmp/mine.txt"); err != nil {
+		t.Fatalf("Directory owner should be able to remove: %v", err)
+	}
+}
+
+func TestPermissionRenameDirectory(t *testing.T) {
+	mf := NewMockFilesystem(WithCredentials(Credentials{Uid: 1000, Gid: 100}))
+	mf.MkdirAll("foo/bar", 0755)
+	mf.Mkdir("baz", 0755)
+	chmod(t, mf, "foo/bar", 0555)
+	ExpectError(t, syscall.EACCES, mf.Rename("foo/bar", "baz/bar"))
+	if err := mf.Rename("foo/bar", "foo/qux"); err != nil {
+		t.Fatalf("Renaming within the same directory should succeed: %v", err)
+	}
+}
+
+func TestPermissionOwnerOnly(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Umask(0)
+	mf.OpenFile("foo.txt", os.O_RDWR|os.O_CREATE, 0666)
+	mf.SetCredentials(&Credentials{Uid: 1000, Gid: 100})
+	f, err := mf.OpenFile("foo.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("World writable file should open: %v", err)
+	}
+	defer f.Close()
+	ExpectPathError(t, "chmod", "foo.txt", syscall.EPERM, f.Chmod(0777))
+	now := time.Now()
+	ExpectPathError(t, "chtimes", "foo.txt", syscall.EPERM, mf.Chtimes("foo.txt", now, now))
+	if err = mf.Chtimes("foo.txt", time.Time{}, time.Time{}); err != nil {
+		t.Fatalf("Chtimes leaving both times unchanged should succeed: %v", err)
+	}
+}
+
+func ExpectOwner(t *testing.T, uid int, gid int, path string, mf *MockFilesystem) {
+	fi, err := mf.Lstat(path)
+	if err != nil {
+		t.Fatalf("Could not stat %v: %v", path, err)
+	}
+	mfi := fi.(*MockFileInfo)
+	if mfi.uid != uid || mfi.gid != gid {
+		t.Fatalf("Owner of %v is %v:%v, expected %v:%v", path, mfi.uid, mfi.gid, uid, gid)
+	}
+}
+
+func TestChown(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Create("foo.txt")
+	mf.Symlink("foo.txt", "bar")
+	if err := mf.Chown("bar", 1000, 100); err != nil {
+		t.Fatalf("Chown should not return error: %v", err)
+	}
+	ExpectOwner(t, 1000, 100, "foo.txt", mf)
+	ExpectOwner(t, 0, 0, "bar", mf)
+	if err := mf.Lchown("bar", 1001, -1); err != nil {
+		t.Fatalf("Lchown should not return error: %v", err)
+	}
+	ExpectOwner(t, 1001, 0, "bar", mf)
+	ExpectOwner(t, 1000, 100, "foo.txt", mf)
+	f, _ := mf.Open("foo.txt")
+	defer f.Close()
+	if err := f.Chown(-1, 200); err != nil {
+		t.Fatalf("File.Chown should not return error: %v", err)
+	}
+	ExpectOwner(t, 1000, 200, "foo.txt", mf)
+	ExpectPathError(t, "chown", "missing", fs.ErrNotExist, mf.Chown("missing", 0, 0))
+	ExpectPathError(t, "lchown", "missing", fs.ErrNotExist, mf.Lchown("missing", 0, 0))
+}
+
+func TestChownClearsSetuid(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.OpenFile("foo", os.O_RDWR|os.O_CREATE, 0755|os.ModeSetuid|os.ModeSetgid)
+	mf.Mkdir("bar", 0755|os.ModeSetgid)
+	mf.Chown("foo", 1000, 100)
+	mf.Chown("bar", 1000, 100)
+	fi, _ := mf.Stat("foo")
+	if fi.Mode() != 0755 {
+		t.Fatalf("Mode %v, expected setuid and setgid to be cleared", fi.Mode())
+	}
+	fi, _ = mf.Stat("bar")
+	if fi.Mode() != os.ModeDir|os.ModeSetgid|0755 {
+		t.Fatalf("Mode %v, expected setgid to be kept on directory", fi.Mode())
+	}
+}
+
+func TestChownPermission(t *testing.T) {
+	mf := NewMockFilesystem(WithCredentials(Credentials{Uid: 1000, Gid: 100, Groups: []int{200}}))
+	mf.Create("foo.txt")
+	ExpectPathError(t, "chown", "foo.txt", syscall.EPERM, mf.Chown("foo.txt", 1001, -1))
+	ExpectPathError(t, "chown", "foo.txt", syscall.EPERM, mf.Chown("foo.txt", -1, 300))
+	if err := mf.Chown("foo.txt", 1000, 200); err != nil {
+		t.Fatalf("Owner should be able to change to a member group: %v", err)
+	}
+	ExpectOwner(t, 1000, 200, "foo.txt", mf)
+	mf.SetCredentials(&Credentials{Uid: 1001, Gid: 200})
+	ExpectPathError(t, "chown", "foo.txt", syscall.EPERM, mf.Chown("foo.txt", -1, 200))
+	mf.SetCredentials(&Credentials{Uid: 0, Gid: 0})
+	if err := mf.Chown("foo.txt", 1001, 300); err != nil {
+		t.Fatalf("Root should be able to give files away: %v", err)
+	}
+	ExpectOwner(t, 1001, 300, "foo.txt", mf)
+}
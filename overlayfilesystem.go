@@ -0,0 +1,1217 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A copy-on-write Filesystem which reads through to a lower Filesystem but
+// makes every change in an upper MockFilesystem, so that code can be run
+// against a real tree without modifying it.  Both layers share one
+// namespace: the overlay path "/a/b" is "/a/b" in each.
+//
+// Changing a file copies it, and the directories above it, into the upper
+// layer first.  Removing a file records a whiteout which hides it in the
+// lower layer, and a directory created in its place is opaque, showing
+// none of the lower directory's children.  Renaming a directory copies its
+// whole tree up before moving it.  Directories list the merged contents of
+// both layers.  Ownership and extended attributes are not copied up.
+type OverlayFilesystem struct {
+	mu     sync.Mutex
+	lower  Filesystem
+	upper  *MockFilesystem
+	cwd    string
+	masked map[string]bool
+	copied map[string]bool
+}
+
+// Returns an overlay of upper, which receives all changes, over lower,
+// which is never modified.  The working directory starts as that of lower.
+func NewOverlayFilesystem(lower Filesystem, upper *MockFilesystem) *OverlayFilesystem {
+	cwd := "/"
+	if wd, err := lower.Getwd(); err == nil && path.IsAbs(wd) {
+		cwd = path.Clean(wd)
+	}
+	return &OverlayFilesystem{
+		lower:  lower,
+		upper:  upper,
+		cwd:    cwd,
+		masked: map[string]bool{},
+		copied: map[string]bool{},
+	}
+}
+
+// The kind of change an OverlayFilesystem has made to a path.
+type ChangeKind int
+
+const (
+	ChangeAdd    ChangeKind = iota // The path does not exist in the lower layer.
+	ChangeModify                   // The path in the lower layer was changed or replaced.
+	ChangeDelete                   // The path in the lower layer was removed.
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdd:
+		return "A"
+	case ChangeModify:
+		return "C"
+	case ChangeDelete:
+		return "D"
+	}
+	return "?"
+}
+
+// A path changed by an OverlayFilesystem.
+type Change struct {
+	Path string
+	Kind ChangeKind
+}
+
+func (c Change) String() string {
+	return c.Kind.String() + " " + c.Path
+}
+
+// Returns the paths changed in the upper layer, sorted by path.  Entries
+// created, modified or replaced are reported, but not directories which
+// were only copied up to hold them.  A removed directory is reported
+// without its children.
+func (o *OverlayFilesystem) Changes() ([]Change, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var changes []Change
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := o.upper.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			p := path.Join(dir, entry.Name())
+			if !o.copied[p] {
+				kind := ChangeAdd
+				if _, err := o.lower.Lstat(p); err == nil {
+					kind = ChangeModify
+				}
+				changes = append(changes, Change{Path: p, Kind: kind})
+			}
+			if entry.IsDir() {
+				if err = walk(p); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk("/"); err != nil {
+		return nil, err
+	}
+	for p := range o.masked {
+		if _, err := o.upper.Lstat(p); err != nil {
+			changes = append(changes, Change{Path: p, Kind: ChangeDelete})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// Translates name into a clean absolute path without resolving symbolic
+// links.
+func (o *OverlayFilesystem) abs(name string) string {
+	if !strings.HasPrefix(name, "/") {
+		name = o.cwd + "/" + name
+	}
+	return path.Clean(name)
+}
+
+// Translates name into an absolute path whose directory contains no
+// symbolic links.  The final element is not resolved.
+func (o *OverlayFilesystem) resolve(name string) (string, error) {
+	p := o.abs(name)
+	if p == "/" {
+		return p, nil
+	}
+	dir, err := o.realdir(path.Dir(p))
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, path.Base(p)), nil
+}
+
+// Resolves the symbolic links in dir, which must be a directory, across
+// both layers.
+func (o *OverlayFilesystem) realdir(dir string) (string, error) {
+	cur := "/"
+	pending := strings.Split(dir, "/")
+	for links := 0; len(pending) > 0; {
+		elem := pending[0]
+		pending = pending[1:]
+		if elem == "" || elem == "." {
+			continue
+		}
+		next := path.Join(cur, elem)
+		fi, _, err := o.lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if links++; links > maxSymlinks {
+				return "", newPathError("lstat", next, errSymlinkLoop)
+			}
+			target, err := o.readlink(next)
+			if err != nil {
+				return "", err
+			}
+			if path.IsAbs(target) {
+				cur = "/"
+			}
+			pending = append(strings.Split(target, "/"), pending...)
+			continue
+		}
+		if !fi.IsDir() {
+			return "", newPathError("lstat", next, syscall.ENOTDIR)
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// Follows p, from resolve, through any symbolic links.  If the last link
+// is dangling its target is returned with the error.
+func (o *OverlayFilesystem) follow(p string) (string, os.FileInfo, error) {
+	for links := 0; ; {
+		fi, _, err := o.lstat(p)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			return p, fi, err
+		}
+		if links++; links > maxSymlinks {
+			return p, nil, newPathError("stat", p, errSymlinkLoop)
+		}
+		target, err := o.readlink(p)
+		if err != nil {
+			return p, nil, err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		if p, err = o.resolve(target); err != nil {
+			return p, nil, err
+		}
+	}
+}
+
+// Reports whether p, or a directory above it, hides the lower layer.
+func (o *OverlayFilesystem) isMasked(p string) bool {
+	for {
+		if o.masked[p] {
+			return true
+		}
+		if p == "/" {
+			return false
+		}
+		p = path.Dir(p)
+	}
+}
+
+// Reports whether p is visible in the lower layer, ignoring any whiteout
+// of p itself.
+func (o *OverlayFilesystem) inLower(p string) bool {
+	if p != "/" && o.isMasked(path.Dir(p)) {
+		return false
+	}
+	_, err := o.lower.Lstat(p)
+	return err == nil
+}
+
+// Returns information about p and whether it is in the upper layer.
+func (o *OverlayFilesystem) lstat(p string) (os.FileInfo, bool, error) {
+	fi, err := o.upper.Lstat(p)
+	if err == nil {
+		return fi, true, nil
+	}
+	if !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTDIR) {
+		return nil, false, err
+	}
+	if o.isMasked(p) {
+		return nil, false, newPathError("lstat", p, syscall.ENOENT)
+	}
+	fi, err = o.lower.Lstat(p)
+	return fi, false, err
+}
+
+func (o *OverlayFilesystem) readlink(p string) (string, error) {
+	if _, err := o.upper.Lstat(p); err == nil {
+		return o.upper.Readlink(p)
+	}
+	return o.lower.Readlink(p)
+}
+
+// Returns the merged entries of the directory p, sorted by name.
+func (o *OverlayFilesystem) readdir(p string) ([]os.FileInfo, error) {
+	var fis []os.FileInfo
+	seen := map[string]bool{}
+	inUpper := false
+	if entries, err := o.upper.ReadDir(p); err == nil {
+		inUpper = true
+		for _, entry := range entries {
+			fi, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			fis = append(fis, fi)
+			seen[entry.Name()] = true
+		}
+	}
+	if !o.isMasked(p) {
+		entries, err := o.lower.ReadDir(p)
+		if err != nil && !(inUpper && os.IsNotExist(err)) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if seen[entry.Name()] || o.masked[path.Join(p, entry.Name())] {
+				continue
+			}
+			fi, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			fis = append(fis, fi)
+		}
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	return fis, nil
+}
+
+// Copies p, which must be visible, and the directories above it into the
+// upper layer if they are not already there.  Copies are made without
+// permission checks, as overlayfs copies up with the mounter's privileges,
+// and are marked as unchanged until modified is called.
+func (o *OverlayFilesystem) copyUp(p string) error {
+	if _, err := o.upper.Lstat(p); err == nil {
+		return nil
+	}
+	fi, err := o.lower.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if err = o.copyUp(path.Dir(p)); err != nil {
+		return err
+	}
+	var data []byte
+	switch mode := fi.Mode(); {
+	case mode&os.ModeSymlink != 0:
+		var target string
+		target, err = o.lower.Readlink(p)
+		data = []byte(target)
+	case mode.IsRegular():
+		data, err = o.lower.ReadFile(p)
+	}
+	if err == nil {
+		err = o.upper.copyIn(p, fi, data)
+	}
+	if err != nil {
+		return err
+	}
+	o.copied[p] = true
+	return nil
+}
+
+// Copies p and everything beneath it into the upper layer.
+func (o *OverlayFilesystem) copyUpTree(p string) error {
+	if err := o.copyUp(p); err != nil {
+		return err
+	}
+	fi, err := o.upper.Lstat(p)
+	if err != nil || !fi.IsDir() {
+		return err
+	}
+	fis, err := o.readdir(p)
+	if err != nil {
+		return err
+	}
+	for _, child := range fis {
+		if err = o.copyUpTree(path.Join(p, child.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Records that p in the upper layer differs from the lower layer.
+func (o *OverlayFilesystem) modified(p string) {
+	delete(o.copied, p)
+}
+
+// Records that the entries of the directory holding p have changed.
+func (o *OverlayFilesystem) modifiedParent(p string) {
+	o.modified(path.Dir(p))
+}
+
+// Records each of paths as modified if err is nil, and returns err.
+func (o *OverlayFilesystem) record(err error, paths ...string) error {
+	if err == nil {
+		for _, p := range paths {
+			o.modified(p)
+		}
+	}
+	return err
+}
+
+// Copies up the directory which will hold p, whose entries are about to
+// change.  The directory is only recorded as modified once they have.
+func (o *OverlayFilesystem) prepare(p string) error {
+	return o.copyUp(path.Dir(p))
+}
+
+// Forgets what is recorded about the paths beneath p.
+func (o *OverlayFilesystem) forget(p string) {
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for q := range o.masked {
+		if strings.HasPrefix(q, prefix) {
+			delete(o.masked, q)
+		}
+	}
+	for q := range o.copied {
+		if strings.HasPrefix(q, prefix) {
+			delete(o.copied, q)
+		}
+	}
+}
+
+// Records that p, now absent from the upper layer, has been removed.
+func (o *OverlayFilesystem) whiteout(p string) {
+	o.forget(p)
+	delete(o.copied, p)
+	if o.inLower(p) {
+		o.masked[p] = true
+	} else {
+		delete(o.masked, p)
+	}
+}
+
+// Reports err, from a layer, against name rather than p.
+func fixPath(err error, p string, name string) error {
+	if perr, ok := err.(*os.PathError); ok && perr.Path == p {
+		return newPathError(perr.Op, name, perr.Err)
+	}
+	return err
+}
+
+func (o *OverlayFilesystem) Chdir(dir string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.resolve(dir)
+	var fi os.FileInfo
+	if err == nil {
+		p, fi, err = o.follow(p)
+	}
+	if err != nil {
+		return newPathError("chdir", dir, underlying(err))
+	}
+	if !fi.IsDir() {
+		return newPathError("chdir", dir, syscall.ENOTDIR)
+	}
+	o.cwd = p
+	return nil
+}
+
+func (o *OverlayFilesystem) Getwd() (dir string, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.cwd, nil
+}
+
+func (o *OverlayFilesystem) Mkdir(name string, perm os.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.mkdir(name, perm)
+}
+
+func (o *OverlayFilesystem) mkdir(name string, perm os.FileMode) error {
+	p, err := o.resolve(name)
+	if err != nil {
+		return newPathError("mkdir", name, underlying(err))
+	}
+	if _, _, err = o.lstat(p); err == nil {
+		return newPathError("mkdir", name, syscall.EEXIST)
+	}
+	if err = o.prepare(p); err != nil {
+		return newPathError("mkdir", name, underlying(err))
+	}
+	return fixPath(o.record(o.upper.Mkdir(p, perm), path.Dir(p)), p, name)
+}
+
+func (o *OverlayFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	dir := ""
+	for _, elem := range strings.Split(o.abs(path), "/")[1:] {
+		if elem == "" {
+			continue
+		}
+		dir += "/" + elem
+		p, err := o.resolve(dir)
+		if err == nil {
+			var fi os.FileInfo
+			if _, fi, err = o.follow(p); err == nil {
+				if !fi.IsDir() {
+					return newPathError("mkdir", dir, syscall.ENOTDIR)
+				}
+				continue
+			}
+		}
+		if !os.IsNotExist(err) {
+			return newPathError("mkdir", dir, underlying(err))
+		}
+		if err = o.mkdir(dir, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *OverlayFilesystem) Remove(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.resolve(name)
+	if err != nil {
+		return newPathError("remove", name, underlying(err))
+	}
+	fi, inUpper, err := o.lstat(p)
+	if err != nil {
+		return newPathError("remove", name, underlying(err))
+	}
+	if p == "/" {
+		return newPathError("remove", name, syscall.EBUSY)
+	}
+	if fi.IsDir() {
+		fis, err := o.readdir(p)
+		if err != nil {
+			return newPathError("remove", name, underlying(err))
+		}
+		if len(fis) > 0 {
+			return newPathError("remove", name, errNotEmpty)
+		}
+	}
+	if err = o.prepare(p); err != nil {
+		return newPathError("remove", name, underlying(err))
+	}
+	if inUpper {
+		if err = o.upper.Remove(p); err != nil {
+			return fixPath(err, p, name)
+		}
+	}
+	o.whiteout(p)
+	o.modifiedParent(p)
+	return nil
+}
+
+// Removes path and any children it contains.  Returns nil if path does not
+// exist.
+func (o *OverlayFilesystem) RemoveAll(path string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.resolve(path)
+	var inUpper bool
+	if err == nil {
+		_, inUpper, err = o.lstat(p)
+	}
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return newPathError("unlinkat", path, underlying(err))
+	}
+	if p == "/" {
+		return newPathError("unlinkat", path, syscall.EBUSY)
+	}
+	if err = o.prepare(p); err != nil {
+		return newPathError("unlinkat", path, underlying(err))
+	}
+	if inUpper {
+		if err = o.upper.RemoveAll(p); err != nil {
+			return fixPath(err, p, path)
+		}
+	}
+	o.whiteout(p)
+	o.modifiedParent(p)
+	return nil
+}
+
+// Renames oldname to newname.  A directory is copied up with everything
+// beneath it before it is moved.
+func (o *OverlayFilesystem) Rename(oldname string, newname string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	oldp, err := o.resolve(oldname)
+	if err != nil {
+		return newLinkError("rename", oldname, newname, underlying(err))
+	}
+	newp, err := o.resolve(newname)
+	if err != nil {
+		return newLinkError("rename", oldname, newname, underlying(err))
+	}
+	if _, _, err = o.lstat(oldp); err != nil {
+		return newLinkError("rename", oldname, newname, underlying(err))
+	}
+	if oldp == newp {
+		return nil
+	}
+	if oldp == "/" || strings.HasPrefix(newp, oldp+"/") {
+		return newLinkError("rename", oldname, newname, syscall.EINVAL)
+	}
+	if fi, _, err := o.lstat(newp); err == nil {
+		if fi.IsDir() {
+			fis, err := o.readdir(newp)
+			if err != nil {
+				return newLinkError("rename", oldname, newname, underlying(err))
+			}
+			if len(fis) > 0 {
+				return newLinkError("rename", oldname, newname, errNotEmpty)
+			}
+		}
+		if err = o.copyUp(newp); err != nil {
+			return newLinkError("rename", oldname, newname, underlying(err))
+		}
+	}
+	if err = o.copyUpTree(oldp); err == nil {
+		if err = o.prepare(oldp); err == nil {
+			err = o.prepare(newp)
+		}
+	}
+	if err != nil {
+		return newLinkError("rename", oldname, newname, underlying(err))
+	}
+	if err = o.upper.Rename(oldp, newp); err != nil {
+		return relink(err, oldname, newname)
+	}
+	o.forget(newp)
+	o.modified(newp)
+	if o.inLower(newp) {
+		o.masked[newp] = true
+	}
+	o.whiteout(oldp)
+	o.modifiedParent(oldp)
+	o.modifiedParent(newp)
+	return nil
+}
+
+func (o *OverlayFilesystem) Create(name string) (file File, err error) {
+	return o.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (o *OverlayFilesystem) Open(name string) (file File, err error) {
+	return o.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Opens the named file.  Opening a file in the lower layer for writing, or
+// with O_TRUNC, copies it up first.
+func (o *OverlayFilesystem) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.resolve(name)
+	if err != nil {
+		return nil, newPathError("open", name, underlying(err))
+	}
+	var fi os.FileInfo
+	if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		if _, _, err = o.lstat(p); err == nil {
+			return nil, newPathError("open", name, syscall.EEXIST)
+		}
+	} else {
+		p, fi, err = o.follow(p)
+	}
+	if err != nil && (!os.IsNotExist(err) || flag&os.O_CREATE == 0) {
+		return nil, newPathError("open", name, underlying(err))
+	}
+	write := isWritable(flag) || flag&os.O_TRUNC != 0
+	switch {
+	case fi == nil:
+		err = o.prepare(p)
+	case fi.IsDir() && write:
+		return nil, newPathError("open", name, syscall.EISDIR)
+	case write:
+		err = o.copyUp(p)
+	}
+	if err != nil {
+		return nil, newPathError("open", name, underlying(err))
+	}
+	layer, lower := Filesystem(o.upper), false
+	if fi != nil && !write {
+		if _, err = o.upper.Lstat(p); err != nil {
+			layer, lower = o.lower, true
+		}
+	}
+	if file, err = layer.OpenFile(p, flag, perm); err != nil {
+		return nil, fixPath(err, p, name)
+	}
+	if fi == nil {
+		o.modifiedParent(p)
+	} else if flag&os.O_TRUNC != 0 {
+		o.modified(p)
+	}
+	return &overlayFile{
+		file:    file,
+		overlay: o,
+		name:    name,
+		path:    p,
+		lower:   lower,
+		dir:     fi != nil && fi.IsDir(),
+	}, nil
+}
+
+func (o *OverlayFilesystem) Stat(name string) (fi os.FileInfo, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.resolve(name)
+	if err == nil {
+		_, fi, err = o.follow(p)
+	}
+	if err != nil {
+		return nil, newPathError("stat", name, underlying(err))
+	}
+	return fi, nil
+}
+
+func (o *OverlayFilesystem) Lstat(name string) (fi os.FileInfo, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.resolve(name)
+	if err == nil {
+		fi, _, err = o.lstat(p)
+	}
+	if err != nil {
+		return nil, newPathError("lstat", name, underlying(err))
+	}
+	return fi, nil
+}
+
+func (o *OverlayFilesystem) Symlink(oldname string, newname string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.resolve(newname)
+	if err == nil {
+		if _, _, err = o.lstat(p); err == nil {
+			err = syscall.EEXIST
+		} else if os.IsNotExist(err) {
+			err = o.prepare(p)
+		}
+	}
+	if err != nil {
+		return newLinkError("symlink", oldname, newname, underlying(err))
+	}
+	return relink(o.record(o.upper.Symlink(oldname, p), path.Dir(p)), oldname, newname)
+}
+
+func (o *OverlayFilesystem) Readlink(name string) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.resolve(name)
+	if err == nil {
+		if _, _, err = o.lstat(p); err == nil {
+			var target string
+			if target, err = o.readlink(p); err == nil {
+				return target, nil
+			}
+		}
+	}
+	return "", newPathError("readlink", name, underlying(err))
+}
+
+func (o *OverlayFilesystem) Link(oldname string, newname string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	oldp, err := o.resolve(oldname)
+	if err == nil {
+		_, _, err = o.lstat(oldp)
+	}
+	if err != nil {
+		return newLinkError("link", oldname, newname, underlying(err))
+	}
+	newp, err := o.resolve(newname)
+	if err == nil {
+		if _, _, err = o.lstat(newp); err == nil {
+			err = syscall.EEXIST
+		} else if os.IsNotExist(err) {
+			if err = o.copyUp(oldp); err == nil {
+				err = o.prepare(newp)
+			}
+		}
+	}
+	if err != nil {
+		return newLinkError("link", oldname, newname, underlying(err))
+	}
+	return relink(o.record(o.upper.Link(oldp, newp), oldp, path.Dir(newp)), oldname, newname)
+}
+
+// Copies up the file name refers to, following symbolic links if follow
+// is set, and returns its path for a change to its attributes.  The caller
+// records the change once it succeeds.
+func (o *OverlayFilesystem) change(op string, name string, follow bool) (string, error) {
+	p, err := o.resolve(name)
+	if err == nil {
+		if follow {
+			p, _, err = o.follow(p)
+		} else {
+			_, _, err = o.lstat(p)
+		}
+	}
+	if err == nil {
+		err = o.copyUp(p)
+	}
+	if err != nil {
+		return "", newPathError(op, name, underlying(err))
+	}
+	return p, nil
+}
+
+func (o *OverlayFilesystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.change("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	return fixPath(o.record(o.upper.Chtimes(p, atime, mtime), p), p, name)
+}
+
+func (o *OverlayFilesystem) Chown(name string, uid int, gid int) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.change("chown", name, true)
+	if err != nil {
+		return err
+	}
+	return fixPath(o.record(o.upper.Chown(p, uid, gid), p), p, name)
+}
+
+func (o *OverlayFilesystem) Lchown(name string, uid int, gid int) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.change("lchown", name, false)
+	if err != nil {
+		return err
+	}
+	return fixPath(o.record(o.upper.Lchown(p, uid, gid), p), p, name)
+}
+
+func (o *OverlayFilesystem) Chmod(name string, mode os.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.change("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	return fixPath(o.record(o.upper.Chmod(p, mode), p), p, name)
+}
+
+func (o *OverlayFilesystem) Truncate(name string, size int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, err := o.resolve(name)
+	if err == nil {
+		var fi os.FileInfo
+		if p, fi, err = o.follow(p); err == nil && fi.IsDir() {
+			err = syscall.EISDIR
+		} else if err == nil {
+			err = o.copyUp(p)
+		}
+	}
+	if err != nil {
+		return newPathError("truncate", name, underlying(err))
+	}
+	return fixPath(o.record(o.upper.Truncate(p, size), p), p, name)
+}
+
+// Returns the merged entries of the named directory, sorted by name.
+func (o *OverlayFilesystem) ReadDir(name string) ([]os.DirEntry, error) {
+	f, err := o.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.ReadDir(-1)
+}
+
+func (o *OverlayFilesystem) ReadFile(name string) ([]byte, error) {
+	f, err := o.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (o *OverlayFilesystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	f, err := o.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (o *OverlayFilesystem) MkdirTemp(dir string, pattern string) (string, error) {
+	if dir == "" {
+		dir = o.TempDir()
+	}
+	prefix, suffix, err := tempPattern(dir, pattern)
+	if err != nil {
+		return "", newPathError("mkdirtemp", pattern, err)
+	}
+	for try := 0; try < maxTempTries; try++ {
+		name := o.upper.tempName(prefix, suffix)
+		if err = o.Mkdir(name, 0700); err == nil {
+			return name, nil
+		} else if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+	}
+	return "", newPathError("mkdirtemp", prefix+"*"+suffix, syscall.EEXIST)
+}
+
+func (o *OverlayFilesystem) CreateTemp(dir string, pattern string) (file File, err error) {
+	if dir == "" {
+		dir = o.TempDir()
+	}
+	prefix, suffix, err := tempPattern(dir, pattern)
+	if err != nil {
+		return nil, newPathError("createtemp", pattern, err)
+	}
+	for try := 0; try < maxTempTries; try++ {
+		name := o.upper.tempName(prefix, suffix)
+		if file, err = o.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600); err == nil {
+			return file, nil
+		} else if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+	}
+	return nil, newPathError("createtemp", prefix+"*"+suffix, syscall.EEXIST)
+}
+
+// Returns the temporary directory of the lower layer.
+func (o *OverlayFilesystem) TempDir() string {
+	return o.lower.TempDir()
+}
+
+// A File opened from an OverlayFilesystem.  Directories list the merged
+// contents of both layers.  Files in the lower layer are only ever open
+// for reading, and changing their attributes copies them up and moves the
+// handle to the copy.
+type overlayFile struct {
+	overlay *OverlayFilesystem
+	name    string
+	path    string
+	dir     bool
+	mu      sync.Mutex
+	file    File
+	lower   bool
+	dirents []os.FileInfo
+	listed  bool
+}
+
+// Returns the underlying file and whether it is in the lower layer.
+func (f *overlayFile) current() (File, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file, f.lower
+}
+
+// Reports err against the name the file was opened with.
+func (f *overlayFile) fix(err error) error {
+	return fixPath(err, f.path, f.name)
+}
+
+// Records that the file has been changed through this handle.
+func (f *overlayFile) wrote() {
+	f.overlay.mu.Lock()
+	f.overlay.modified(f.path)
+	f.overlay.mu.Unlock()
+}
+
+// Fails with EBADF if the file is in the lower layer, which is only open
+// for reading.  Otherwise returns the file to write to.
+func (f *overlayFile) writable(op string) (File, error) {
+	file, lower := f.current()
+	if lower {
+		return nil, newPathError(op, f.name, errBadFd)
+	}
+	return file, nil
+}
+
+func (f *overlayFile) Chdir() error {
+	if !f.dir {
+		return newPathError("chdir", f.name, syscall.ENOTDIR)
+	}
+	f.overlay.mu.Lock()
+	f.overlay.cwd = f.path
+	f.overlay.mu.Unlock()
+	return nil
+}
+
+// Copies the file up if it is still in the lower layer and moves the
+// handle to the copy, keeping its offset.  Must be called with the overlay
+// locked.
+func (f *overlayFile) copyUp(op string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.lower {
+		return nil
+	}
+	p, err := f.overlay.change(op, f.path, false)
+	if err != nil {
+		return err
+	}
+	upper, err := f.overlay.upper.Open(p)
+	if err != nil {
+		return err
+	}
+	if !f.dir {
+		off, err := f.file.Seek(0, io.SeekCurrent)
+		if err == nil {
+			_, err = upper.Seek(off, io.SeekStart)
+		}
+		if err != nil {
+			upper.Close()
+			return err
+		}
+	}
+	f.file.Close()
+	f.file, f.lower = upper, false
+	return nil
+}
+
+// Applies fn to the file in the upper layer, copying it up if necessary.
+func (f *overlayFile) change(op string, fn func(file File) error) error {
+	f.overlay.mu.Lock()
+	defer f.overlay.mu.Unlock()
+	if err := f.copyUp(op); err != nil {
+		return f.fix(err)
+	}
+	file, _ := f.current()
+	return f.fix(f.overlay.record(fn(file), f.path))
+}
+
+func (f *overlayFile) Chmod(mode os.FileMode) error {
+	return f.change("chmod", func(file File) error {
+		return file.Chmod(mode)
+	})
+}
+
+func (f *overlayFile) Chown(uid int, gid int) error {
+	return f.change("chown", func(file File) error {
+		return file.Chown(uid, gid)
+	})
+}
+
+func (f *overlayFile) Close() error {
+	file, _ := f.current()
+	return f.fix(file.Close())
+}
+
+func (f *overlayFile) Fd() uintptr {
+	file, _ := f.current()
+	return file.Fd()
+}
+
+func (f *overlayFile) Name() string {
+	return f.name
+}
+
+func (f *overlayFile) Read(b []byte) (n int, err error) {
+	file, _ := f.current()
+	n, err = file.Read(b)
+	return n, f.fix(err)
+}
+
+func (f *overlayFile) ReadAt(b []byte, off int64) (n int, err error) {
+	file, _ := f.current()
+	n, err = file.ReadAt(b, off)
+	return n, f.fix(err)
+}
+
+func (f *overlayFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	fi, err := f.Readdir(n)
+	entries := make([]fs.DirEntry, len(fi))
+	for i, info := range fi {
+		entries[i] = fs.FileInfoToDirEntry(info)
+	}
+	return entries, err
+}
+
+func (f *overlayFile) ReadFrom(r io.Reader) (n int64, err error) {
+	file, err := f.writable("write")
+	if err != nil {
+		return 0, err
+	}
+	if n, err = file.ReadFrom(r); n > 0 {
+		f.wrote()
+	}
+	return n, f.fix(err)
+}
+
+// Returns the merged entries of a directory in the same way as
+// MockFile.Readdir.
+func (f *overlayFile) Readdir(n int) (fi []os.FileInfo, err error) {
+	if !f.dir {
+		file, _ := f.current()
+		fi, err = file.Readdir(n)
+		return fi, f.fix(err)
+	}
+	f.overlay.mu.Lock()
+	defer f.overlay.mu.Unlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.listed {
+		if f.dirents, err = f.overlay.readdir(f.path); err != nil {
+			return nil, f.fix(err)
+		}
+		f.listed = true
+	}
+	limit := len(f.dirents)
+	if n > 0 {
+		if limit == 0 {
+			return []os.FileInfo{}, io.EOF
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+	fi = f.dirents[:limit:limit]
+	f.dirents = f.dirents[limit:]
+	return fi, nil
+}
+
+func (f *overlayFile) Readdirnames(n int) (names []string, err error) {
+	fi, err := f.Readdir(n)
+	names = make([]string, len(fi))
+	for i, info := range fi {
+		names[i] = info.Name()
+	}
+	return names, err
+}
+
+func (f *overlayFile) SetDeadline(t time.Time) error {
+	file, _ := f.current()
+	return f.fix(file.SetDeadline(t))
+}
+
+func (f *overlayFile) SetReadDeadline(t time.Time) error {
+	file, _ := f.current()
+	return f.fix(file.SetReadDeadline(t))
+}
+
+func (f *overlayFile) SetWriteDeadline(t time.Time) error {
+	file, _ := f.current()
+	return f.fix(file.SetWriteDeadline(t))
+}
+
+func (f *overlayFile) Stat() (fi os.FileInfo, err error) {
+	file, _ := f.current()
+	fi, err = file.Stat()
+	return fi, f.fix(err)
+}
+
+func (f *overlayFile) Sync() (err error) {
+	file, _ := f.current()
+	return f.fix(file.Sync())
+}
+
+func (f *overlayFile) SyscallConn() (syscall.RawConn, error) {
+	file, _ := f.current()
+	conn, err := file.SyscallConn()
+	return conn, f.fix(err)
+}
+
+// Seeking a directory to the start lists it again.
+func (f *overlayFile) Seek(offset int64, whence int) (ret int64, err error) {
+	file, _ := f.current()
+	if ret, err = file.Seek(offset, whence); err != nil {
+		return ret, f.fix(err)
+	}
+	if f.dir && ret == 0 {
+		f.mu.Lock()
+		f.dirents = nil
+		f.listed = false
+		f.mu.Unlock()
+	}
+	return ret, nil
+}
+
+func (f *overlayFile) Truncate(size int64) error {
+	file, err := f.writable("truncate")
+	if err != nil {
+		return err
+	}
+	if err = file.Truncate(size); err == nil {
+		f.wrote()
+	}
+	return f.fix(err)
+}
+
+func (f *overlayFile) Write(b []byte) (n int, err error) {
+	file, err := f.writable("write")
+	if err != nil {
+		return 0, err
+	}
+	if n, err = file.Write(b); n > 0 {
+		f.wrote()
+	}
+	return n, f.fix(err)
+}
+
+func (f *overlayFile) WriteAt(b []byte, off int64) (n int, err error) {
+	file, err := f.writable("write")
+	if err != nil {
+		return 0, err
+	}
+	if n, err = file.WriteAt(b, off); n > 0 {
+		f.wrote()
+	}
+	return n, f.fix(err)
+}
+
+func (f *overlayFile) WriteString(s string) (ret int, err error) {
+	file, err := f.writable("write")
+	if err != nil {
+		return 0, err
+	}
+	if ret, err = file.WriteString(s); ret > 0 {
+		f.wrote()
+	}
+	return ret, f.fix(err)
+}
+
+func (f *overlayFile) WriteTo(w io.Writer) (n int64, err error) {
+	file, _ := f.current()
+	n, err = file.WriteTo(w)
+	return n, f.fix(err)
+}
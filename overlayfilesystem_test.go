@@ -0,0 +1,311 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+// Returns an overlay over a lower tree which tests check is never changed.
+func newTestOverlay(t *testing.T) (*OverlayFilesystem, *MockFilesystem) {
+	lower := NewMockFilesystem()
+	lower.MkdirAll("/src/pkg/util", 0755)
+	lower.MkdirAll("/src/docs", 0755)
+	lower.WriteFile("/src/main.go", []byte("package main"), 0644)
+	lower.WriteFile("/src/pkg/a.go", []byte("package pkg"), 0644)
+	lower.WriteFile("/src/pkg/util/b.go", []byte("package util"), 0600)
+	lower.WriteFile("/src/docs/README", []byte("docs"), 0644)
+	lower.Symlink("pkg", "/src/link")
+	t.Cleanup(func() {
+		ExpectContents(t, "package main", "/src/main.go", lower)
+		ExpectContents(t, "package pkg", "/src/pkg/a.go", lower)
+		ExpectContents(t, "package util", "/src/pkg/util/b.go", lower)
+		ExpectContents(t, "docs", "/src/docs/README", lower)
+		ExpectPerm(t, 0600, "/src/pkg/util/b.go", lower)
+		ExpectNames(t, []string{"docs", "link", "main.go", "pkg"}, "/src", lower)
+		ExpectNames(t, []string{"a.go", "util"}, "/src/pkg", lower)
+	})
+	return NewOverlayFilesystem(lower, NewMockFilesystem()), lower
+}
+
+func ExpectChanges(t *testing.T, expected []string, o *OverlayFilesystem) {
+	changes, err := o.Changes()
+	if err != nil {
+		t.Fatalf("Changes returned error: %v", err)
+	}
+	actual := make([]string, len(changes))
+	for i, change := range changes {
+		actual[i] = change.String()
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected changes %q, got %q", expected, actual)
+	}
+}
+
+func ExpectNames(t *testing.T, expected []string, dir string, fsys Filesystem) {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir returned error: %v", err)
+	}
+	actual := make([]string, len(entries))
+	for i, entry := range entries {
+		actual[i] = entry.Name()
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected %v to contain %v, got %v", dir, expected, actual)
+	}
+}
+
+func TestOverlayCopyUp(t *testing.T) {
+	o, _ := newTestOverlay(t)
+	if data, err := o.ReadFile("/src/link/util/b.go"); err != nil || string(data) != "package util" {
+		t.Fatalf("ReadFile through the lower layer returned %q, %v", data, err)
+	}
+	ExpectChanges(t, []string{}, o)
+	if err := o.WriteFile("/src/pkg/util/b.go", []byte("changed"), 0644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	if data, _ := o.ReadFile("/src/pkg/util/b.go"); string(data) != "changed" {
+		t.Fatalf("ReadFile returned %q after a write", data)
+	}
+	if fi, _ := o.Stat("/src/pkg/util/b.go"); fi.Mode().Perm() != 0600 {
+		t.Fatalf("Copy-up changed the mode to %v", fi.Mode())
+	}
+	if err := o.Chmod("/src/main.go", 0755); err != nil {
+		t.Fatalf("Chmod returned error: %v", err)
+	}
+	if fi, _ := o.Stat("/src/main.go"); fi.Mode().Perm() != 0755 {
+		t.Fatalf("Chmod set the mode to %v", fi.Mode())
+	}
+	f, err := o.Open("/src/docs/README")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer f.Close()
+	if _, err = f.Write([]byte("x")); err == nil {
+		t.Fatalf("Write to a file opened for reading should fail")
+	}
+	ExpectError(t, errBadFd, f.Truncate(0))
+	f, err = o.OpenFile("/src/link/a.go", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile through a symlink returned error: %v", err)
+	}
+	f.Write([]byte("\n"))
+	f.Close()
+	if data, _ := o.ReadFile("/src/pkg/a.go"); string(data) != "package pkg\n" {
+		t.Fatalf("Append through a symlink wrote %q", data)
+	}
+	ExpectChanges(t, []string{
+		"C /src/main.go",
+		"C /src/pkg/a.go",
+		"C /src/pkg/util/b.go",
+	}, o)
+}
+
+func TestOverlayOpenForWriting(t *testing.T) {
+	o, _ := newTestOverlay(t)
+	f, err := o.OpenFile("/src/main.go", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile returned error: %v", err)
+	}
+	f.Close()
+	ExpectChanges(t, []string{}, o)
+	f, _ = o.OpenFile("/src/main.go", os.O_WRONLY, 0)
+	if _, err = f.WriteAt([]byte("P"), 0); err != nil {
+		t.Fatalf("WriteAt returned error: %v", err)
+	}
+	f.Close()
+	ExpectChanges(t, []string{"C /src/main.go"}, o)
+}
+
+func TestOverlayFileChmod(t *testing.T) {
+	o, _ := newTestOverlay(t)
+	f, err := o.Open("/src/docs/README")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer f.Close()
+	buf := make([]byte, 2)
+	f.Read(buf)
+	if err = f.Chmod(0700); err != nil {
+		t.Fatalf("File.Chmod returned error: %v", err)
+	}
+	if fi, _ := f.Stat(); fi.Mode().Perm() != 0700 {
+		t.Fatalf("File.Stat returned mode %v after File.Chmod", fi.Mode())
+	}
+	if fi, _ := o.Stat("/src/docs/README"); fi.Mode().Perm() != 0700 {
+		t.Fatalf("Stat returned mode %v after File.Chmod", fi.Mode())
+	}
+	if n, _ := f.Read(buf); n != 2 || string(buf) != "cs" {
+		t.Fatalf("Read after File.Chmod returned %q", buf[:n])
+	}
+	ExpectChanges(t, []string{"C /src/docs/README"}, o)
+}
+
+func TestOverlayWhiteouts(t *testing.T) {
+	o, _ := newTestOverlay(t)
+	if err := o.Remove("/src/main.go"); err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+	if _, err := o.Stat("/src/main.go"); !os.IsNotExist(err) {
+		t.Fatalf("Expected removed file to be missing, got %v", err)
+	}
+	ExpectError(t, errNotEmpty, o.Remove("/src/docs"))
+	if err := o.RemoveAll("/src/pkg"); err != nil {
+		t.Fatalf("RemoveAll returned error: %v", err)
+	}
+	if _, err := o.Stat("/src/link/a.go"); !os.IsNotExist(err) {
+		t.Fatalf("Expected file in removed directory to be missing, got %v", err)
+	}
+	ExpectNames(t, []string{"docs", "link"}, "/src", o)
+	if err := o.Mkdir("/src/pkg", 0755); err != nil {
+		t.Fatalf("Mkdir returned error: %v", err)
+	}
+	ExpectNames(t, []string{}, "/src/pkg", o)
+	if err := o.WriteFile("/src/pkg/new.go", []byte("new"), 0644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	ExpectNames(t, []string{"new.go"}, "/src/link", o)
+	if err := o.WriteFile("/src/main.go", []byte("again"), 0644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	if err := o.Remove("/src/docs/README"); err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+	ExpectChanges(t, []string{
+		"C /src",
+		"C /src/docs",
+		"D /src/docs/README",
+		"C /src/main.go",
+		"C /src/pkg",
+		"A /src/pkg/new.go",
+	}, o)
+	if err := o.RemoveAll("/src/missing"); err != nil {
+		t.Fatalf("RemoveAll of a missing path returned error: %v", err)
+	}
+}
+
+func TestOverlayRenameDirectory(t *testing.T) {
+	o, _ := newTestOverlay(t)
+	ExpectError(t, syscall.ENOTDIR, o.Rename("/src/docs", "/src/main.go"))
+	ExpectChanges(t, []string{}, o)
+	o.Remove("/src/pkg/a.go")
+	if err := o.Rename("/src/pkg", "/src/lib"); err != nil {
+		t.Fatalf("Rename returned error: %v", err)
+	}
+	if _, err := o.Stat("/src/pkg"); !os.IsNotExist(err) {
+		t.Fatalf("Expected renamed directory to be missing, got %v", err)
+	}
+	if data, err := o.ReadFile("/src/lib/util/b.go"); err != nil || string(data) != "package util" {
+		t.Fatalf("ReadFile after Rename returned %q, %v", data, err)
+	}
+	ExpectNames(t, []string{"util"}, "/src/lib", o)
+	if _, err := o.Stat("/src/link"); !os.IsNotExist(err) {
+		t.Fatalf("Expected dangling symlink, got %v", err)
+	}
+	if err := o.Mkdir("/src/pkg", 0755); err != nil {
+		t.Fatalf("Mkdir returned error: %v", err)
+	}
+	ExpectNames(t, []string{}, "/src/pkg", o)
+	ExpectError(t, errNotEmpty, o.Rename("/src/pkg", "/src/docs"))
+	ExpectError(t, syscall.EINVAL, o.Rename("/src/lib", "/src/lib/util/x"))
+	ExpectChanges(t, []string{
+		"C /src",
+		"A /src/lib",
+		"A /src/lib/util",
+		"A /src/lib/util/b.go",
+		"C /src/pkg",
+	}, o)
+}
+
+func TestOverlayWorkingDirectory(t *testing.T) {
+	o, _ := newTestOverlay(t)
+	if err := o.Chdir("/src/link"); err != nil {
+		t.Fatalf("Chdir returned error: %v", err)
+	}
+	if cwd, _ := o.Getwd(); cwd != "/src/pkg" {
+		t.Fatalf("Getwd returned %v, expected /src/pkg", cwd)
+	}
+	f, err := o.Create("c.go")
+	if err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+	f.Close()
+	if f.Name() != "c.go" {
+		t.Fatalf("Name returned %v, expected c.go", f.Name())
+	}
+	_, err = o.Open("missing.go")
+	ExpectPathError(t, "open", "missing.go", syscall.ENOENT, err)
+	ExpectNames(t, []string{"a.go", "c.go", "util"}, ".", o)
+	ExpectChanges(t, []string{"C /src/pkg", "A /src/pkg/c.go"}, o)
+}
+
+func TestOverlayCopyUpPermissions(t *testing.T) {
+	lower := NewMockFilesystem()
+	lower.Mkdir("/ro", 0755)
+	lower.WriteFile("/ro/f", []byte("lower"), 0644)
+	lower.WriteFile("/ro/g", []byte("lower"), 0644)
+	lower.Chmod("/ro/f", 0666)
+	lower.Chmod("/ro", 0555)
+	upper := NewMockFilesystem(WithCredentials(Credentials{Uid: 1000, Gid: 100}))
+	o := NewOverlayFilesystem(lower, upper)
+	if err := o.WriteFile("/ro/f", []byte("upper"), 0644); err != nil {
+		t.Fatalf("Could not write a world-writable file: %v", err)
+	}
+	ExpectContents(t, "upper", "/ro/f", upper)
+	ExpectContents(t, "lower", "/ro/f", lower)
+	ExpectPerm(t, 0555, "/ro", upper)
+	ExpectPerm(t, 0666, "/ro/f", upper)
+	ExpectOwner(t, 0, 0, "/ro/f", upper)
+	ExpectError(t, syscall.EACCES, o.WriteFile("/ro/g", []byte("upper"), 0644))
+	ExpectError(t, syscall.EACCES, o.WriteFile("/ro/h", []byte("upper"), 0644))
+	ExpectError(t, syscall.EACCES, o.Remove("/ro/f"))
+	ExpectContents(t, "lower", "/ro/g", lower)
+	ExpectChanges(t, []string{"C /ro/f"}, o)
+}
+
+func TestOverlayRealLower(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The overlay uses slash-separated paths")
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "keep.txt"), []byte("keep"), 0644)
+	os.WriteFile(filepath.Join(dir, "drop.txt"), []byte("drop"), 0644)
+	o := NewOverlayFilesystem(&RealFilesystem{}, NewMockFilesystem())
+	o.WriteFile(dir+"/keep.txt", []byte("changed"), 0644)
+	o.Remove(dir + "/drop.txt")
+	o.Mkdir(dir+"/new", 0755)
+	ExpectNames(t, []string{"keep.txt", "new"}, dir, o)
+	if data, _ := os.ReadFile(filepath.Join(dir, "keep.txt")); string(data) != "keep" {
+		t.Fatalf("The lower file was changed to %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "drop.txt")); err != nil {
+		t.Fatalf("The lower file was removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Fatalf("A directory was created in the lower layer: %v", err)
+	}
+	ExpectChanges(t, []string{
+		"C " + dir,
+		"D " + dir + "/drop.txt",
+		"C " + dir + "/keep.txt",
+		"A " + dir + "/new",
+	}, o)
+}
@@ -0,0 +1,283 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Returns a view of fsys which can be read but not modified, as if it
+// were mounted read-only.  Methods which would create, remove or change
+// files, including those of files opened from the view, fail with EROFS
+// without calling fsys.  The view keeps its own working directory, which
+// starts as that of fsys, so Chdir does not change fsys either.
+func ReadOnly(fsys Filesystem) Filesystem {
+	return &readOnlyFilesystem{fsys: fsys}
+}
+
+type readOnlyFilesystem struct {
+	mu   sync.Mutex
+	fsys Filesystem
+	cwd  string
+}
+
+// Resolves name against the working directory.  Returns the prefix added
+// to name, which is empty if name is absolute or Chdir has not been called.
+func (r *readOnlyFilesystem) resolve(name string) (p string, prefix string) {
+	r.mu.Lock()
+	cwd := r.cwd
+	r.mu.Unlock()
+	if cwd == "" || name == "" || strings.HasPrefix(name, "/") {
+		return name, ""
+	}
+	if prefix = cwd; !strings.HasSuffix(cwd, "/") {
+		prefix += "/"
+	}
+	return prefix + name, prefix
+}
+
+func (r *readOnlyFilesystem) Chdir(dir string) error {
+	p, prefix := r.resolve(dir)
+	fi, err := r.fsys.Stat(p)
+	if err != nil {
+		return newPathError("chdir", dir, underlying(unresolve(err, prefix)))
+	}
+	if !fi.IsDir() {
+		return newPathError("chdir", dir, syscall.ENOTDIR)
+	}
+	return r.setwd(p)
+}
+
+// Makes p, a directory, the working directory of the view.
+func (r *readOnlyFilesystem) setwd(p string) error {
+	if !strings.HasPrefix(p, "/") {
+		wd, err := r.Getwd()
+		if err != nil {
+			return err
+		}
+		p = path.Join(wd, p)
+	}
+	r.mu.Lock()
+	r.cwd = path.Clean(p)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *readOnlyFilesystem) Getwd() (dir string, err error) {
+	r.mu.Lock()
+	cwd := r.cwd
+	r.mu.Unlock()
+	if cwd == "" {
+		return r.fsys.Getwd()
+	}
+	return cwd, nil
+}
+
+func (r *readOnlyFilesystem) Mkdir(name string, perm os.FileMode) error {
+	return newPathError("mkdir", name, errReadOnly)
+}
+
+// Succeeds if path is already a directory, as mkdir -p would.
+func (r *readOnlyFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	p, _ := r.resolve(path)
+	if fi, err := r.fsys.Stat(p); err == nil && fi.IsDir() {
+		return nil
+	}
+	return newPathError("mkdir", path, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) Remove(name string) error {
+	return newPathError("remove", name, errReadOnly)
+}
+
+// Does nothing if path does not exist, like os.RemoveAll.
+func (r *readOnlyFilesystem) RemoveAll(path string) error {
+	p, _ := r.resolve(path)
+	if _, err := r.fsys.Lstat(p); os.IsNotExist(err) {
+		return nil
+	}
+	return newPathError("unlinkat", path, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) Rename(oldname string, newname string) error {
+	return newLinkError("rename", oldname, newname, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) Create(name string) (file File, err error) {
+	return nil, newPathError("open", name, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) Open(name string) (file File, err error) {
+	return r.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Opens the named file for reading.  Flags which would create, truncate or
+// write to the file fail with EROFS.
+func (r *readOnlyFilesystem) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	if isWritable(flag) || flag&(os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, newPathError("open", name, errReadOnly)
+	}
+	p, prefix := r.resolve(name)
+	if file, err = r.fsys.OpenFile(p, flag, perm); err != nil {
+		return nil, unresolve(err, prefix)
+	}
+	return &readOnlyFile{File: file, filesystem: r, name: name, path: p}, nil
+}
+
+func (r *readOnlyFilesystem) Stat(name string) (fi os.FileInfo, err error) {
+	p, prefix := r.resolve(name)
+	fi, err = r.fsys.Stat(p)
+	return fi, unresolve(err, prefix)
+}
+
+func (r *readOnlyFilesystem) Lstat(name string) (fi os.FileInfo, err error) {
+	p, prefix := r.resolve(name)
+	fi, err = r.fsys.Lstat(p)
+	return fi, unresolve(err, prefix)
+}
+
+func (r *readOnlyFilesystem) Symlink(oldname string, newname string) error {
+	return newLinkError("symlink", oldname, newname, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) Readlink(name string) (string, error) {
+	p, prefix := r.resolve(name)
+	target, err := r.fsys.Readlink(p)
+	return target, unresolve(err, prefix)
+}
+
+func (r *readOnlyFilesystem) Link(oldname string, newname string) error {
+	return newLinkError("link", oldname, newname, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return newPathError("chtimes", name, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) Chown(name string, uid int, gid int) error {
+	return newPathError("chown", name, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) Lchown(name string, uid int, gid int) error {
+	return newPathError("lchown", name, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) Chmod(name string, mode os.FileMode) error {
+	return newPathError("chmod", name, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) Truncate(name string, size int64) error {
+	return newPathError("truncate", name, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) ReadDir(name string) ([]os.DirEntry, error) {
+	p, prefix := r.resolve(name)
+	entries, err := r.fsys.ReadDir(p)
+	return entries, unresolve(err, prefix)
+}
+
+func (r *readOnlyFilesystem) ReadFile(name string) ([]byte, error) {
+	p, prefix := r.resolve(name)
+	data, err := r.fsys.ReadFile(p)
+	return data, unresolve(err, prefix)
+}
+
+func (r *readOnlyFilesystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return newPathError("open", name, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) MkdirTemp(dir string, pattern string) (string, error) {
+	return "", newPathError("mkdirtemp", dir, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) CreateTemp(dir string, pattern string) (file File, err error) {
+	return nil, newPathError("createtemp", dir, errReadOnly)
+}
+
+func (r *readOnlyFilesystem) TempDir() string {
+	return r.fsys.TempDir()
+}
+
+// A File opened through a view returned by ReadOnly.  It offers no
+// descriptor, through which it could be changed.
+type readOnlyFile struct {
+	File
+	filesystem *readOnlyFilesystem
+	name       string
+	path       string
+}
+
+// Makes the file, which must be a directory, the working directory of the
+// view.
+func (f *readOnlyFile) Chdir() error {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return newPathError("chdir", f.name, underlying(err))
+	}
+	if !fi.IsDir() {
+		return newPathError("chdir", f.name, syscall.ENOTDIR)
+	}
+	if err = f.filesystem.setwd(f.path); err != nil {
+		return newPathError("chdir", f.name, err)
+	}
+	return nil
+}
+
+// Returns ^uintptr(0), as a closed *os.File does.
+func (f *readOnlyFile) Fd() uintptr {
+	return ^uintptr(0)
+}
+
+func (f *readOnlyFile) Name() string {
+	return f.name
+}
+
+func (f *readOnlyFile) SyscallConn() (syscall.RawConn, error) {
+	return nil, newPathError("syscallconn", f.name, errNotSupported)
+}
+
+func (f *readOnlyFile) Chmod(mode os.FileMode) error {
+	return newPathError("chmod", f.name, errReadOnly)
+}
+
+func (f *readOnlyFile) Chown(uid int, gid int) error {
+	return newPathError("chown", f.name, errReadOnly)
+}
+
+func (f *readOnlyFile) ReadFrom(r io.Reader) (n int64, err error) {
+	return 0, newPathError("write", f.name, errReadOnly)
+}
+
+func (f *readOnlyFile) Truncate(size int64) error {
+	return newPathError("truncate", f.name, errReadOnly)
+}
+
+func (f *readOnlyFile) Write(b []byte) (n int, err error) {
+	return 0, newPathError("write", f.name, errReadOnly)
+}
+
+func (f *readOnlyFile) WriteAt(b []byte, off int64) (n int, err error) {
+	return 0, newPathError("write", f.name, errReadOnly)
+}
+
+func (f *readOnlyFile) WriteString(s string) (ret int, err error) {
+	return 0, newPathError("write", f.name, errReadOnly)
+}
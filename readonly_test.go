@@ -0,0 +1,163 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReadOnlyReads(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.MkdirAll("/data/reports", 0755)
+	mf.WriteFile("/data/reports/q1.csv", []byte("a,b"), 0644)
+	ro := ReadOnly(mf)
+	file, err := ro.Open("/data/reports/q1.csv")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer file.Close()
+	if _, err = file.Seek(2, io.SeekStart); err != nil {
+		t.Fatalf("Seek returned error: %v", err)
+	}
+	if data, err := io.ReadAll(file); err != nil || string(data) != "b" {
+		t.Fatalf("Read returned %q, %v", data, err)
+	}
+	if fi, err := ro.Stat("/data/reports/q1.csv"); err != nil || fi.Size() != 3 {
+		t.Fatalf("Stat returned %v, %v", fi, err)
+	}
+	dir, err := ro.Open("/data/reports")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer dir.Close()
+	if fi, err := dir.Readdir(-1); err != nil || len(fi) != 1 || fi[0].Name() != "q1.csv" {
+		t.Fatalf("Readdir returned %v, %v", fi, err)
+	}
+	if err = ro.MkdirAll("/data", 0755); err != nil {
+		t.Fatalf("MkdirAll of an existing directory returned error: %v", err)
+	}
+	if err = ro.RemoveAll("/missing"); err != nil {
+		t.Fatalf("RemoveAll of a missing path returned error: %v", err)
+	}
+}
+
+func TestReadOnlyNeverWrites(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.MkdirAll("/data", 0755)
+	mf.WriteFile("/data/prod.db", []byte("rows"), 0644)
+	mf.Symlink("/data/prod.db", "/data/link")
+	before, _ := mf.Stat("/data/prod.db")
+	ro := ReadOnly(mf)
+	ExpectError(t, errReadOnly, ro.Mkdir("/data/new", 0755))
+	ExpectError(t, errReadOnly, ro.MkdirAll("/data/new/deep", 0755))
+	ExpectError(t, errReadOnly, ro.Remove("/data/prod.db"))
+	ExpectError(t, errReadOnly, ro.RemoveAll("/data"))
+	ExpectError(t, errReadOnly, ro.Rename("/data/prod.db", "/data/old.db"))
+	ExpectError(t, errReadOnly, ro.Symlink("/data/prod.db", "/data/new"))
+	ExpectError(t, errReadOnly, ro.Link("/data/prod.db", "/data/new"))
+	ExpectError(t, errReadOnly, ro.Chtimes("/data/prod.db", time.Now(), time.Now()))
+	ExpectError(t, errReadOnly, ro.Chown("/data/prod.db", 1, 1))
+	ExpectError(t, errReadOnly, ro.Lchown("/data/link", 1, 1))
+	ExpectError(t, errReadOnly, ro.Chmod("/data/prod.db", 0777))
+	ExpectError(t, errReadOnly, ro.Truncate("/data/prod.db", 0))
+	ExpectError(t, errReadOnly, ro.WriteFile("/data/prod.db", nil, 0644))
+	_, err := ro.Create("/data/new")
+	ExpectError(t, errReadOnly, err)
+	_, err = ro.MkdirTemp("/data", "tmp")
+	ExpectError(t, errReadOnly, err)
+	_, err = ro.CreateTemp("/data", "tmp")
+	ExpectError(t, errReadOnly, err)
+	flags := []int{
+		os.O_WRONLY,
+		os.O_RDWR,
+		os.O_RDONLY | os.O_CREATE,
+		os.O_RDONLY | os.O_TRUNC,
+		os.O_RDONLY | os.O_APPEND,
+	}
+	for _, flag := range flags {
+		_, err = ro.OpenFile("/data/prod.db", flag, 0644)
+		ExpectError(t, errReadOnly, err)
+	}
+
+	file, err := ro.Open("/data/prod.db")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer file.Close()
+	_, err = file.Write([]byte("x"))
+	ExpectError(t, errReadOnly, err)
+	_, err = file.WriteAt([]byte("x"), 0)
+	ExpectError(t, errReadOnly, err)
+	_, err = file.WriteString("x")
+	ExpectError(t, errReadOnly, err)
+	_, err = file.ReadFrom(strings.NewReader("x"))
+	ExpectError(t, errReadOnly, err)
+	ExpectError(t, errReadOnly, file.Truncate(0))
+	ExpectError(t, errReadOnly, file.Chmod(0777))
+	ExpectError(t, errReadOnly, file.Chown(1, 1))
+	if fd := file.Fd(); fd != ^uintptr(0) {
+		t.Fatalf("Fd returned descriptor %v", fd)
+	}
+	_, err = file.SyscallConn()
+	ExpectError(t, errors.ErrUnsupported, err)
+
+	after, _ := mf.Stat("/data/prod.db")
+	if after.Mode() != before.Mode() || !after.ModTime().Equal(before.ModTime()) || after.Size() != before.Size() {
+		t.Fatalf("File changed from %v to %v", before, after)
+	}
+	if entries, _ := mf.ReadDir("/data"); len(entries) != 2 {
+		t.Fatalf("Directory changed to %v", entries)
+	}
+	if data, _ := mf.ReadFile("/data/prod.db"); string(data) != "rows" {
+		t.Fatalf("File contents changed to %q", data)
+	}
+}
+
+func TestReadOnlyChdir(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.MkdirAll("/data/reports", 0755)
+	mf.WriteFile("/data/reports/q1.csv", []byte("a,b"), 0644)
+	ro := ReadOnly(mf)
+	if err := ro.Chdir("/data"); err != nil {
+		t.Fatalf("Chdir returned error: %v", err)
+	}
+	ExpectCwd(t, "/", mf)
+	if cwd, _ := ro.Getwd(); cwd != "/data" {
+		t.Fatalf("Getwd returned %v, expected /data", cwd)
+	}
+	if data, err := ro.ReadFile("reports/q1.csv"); err != nil || string(data) != "a,b" {
+		t.Fatalf("Relative ReadFile returned %q, %v", data, err)
+	}
+	_, err := ro.Stat("missing.csv")
+	ExpectPathError(t, "stat", "missing.csv", syscall.ENOENT, err)
+	dir, err := ro.Open("reports")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer dir.Close()
+	if err = dir.Chdir(); err != nil {
+		t.Fatalf("File.Chdir returned error: %v", err)
+	}
+	ExpectCwd(t, "/", mf)
+	if _, err = ro.Stat("q1.csv"); err != nil {
+		t.Fatalf("Stat after File.Chdir returned error: %v", err)
+	}
+}
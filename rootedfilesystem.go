@@ -0,0 +1,400 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A Filesystem confined to a directory on disk.  The directory appears as
+// "/", and relative names are resolved against a working directory set
+// with Chdir.  Names are cleaned lexically, so ".." never leaves the root,
+// and files are opened relative to a descriptor for the root with os.Root,
+// so symbolic links which point outside it fail instead of being followed.
+// Errors and File.Name report names as the caller passed them, never the
+// location of the root on disk.
+type RootedFilesystem struct {
+	mu   sync.Mutex
+	root *os.Root
+	cwd  string
+}
+
+// Opens dir as the root of a new RootedFilesystem.  Close releases it.
+func NewRootedFilesystem(dir string) (*RootedFilesystem, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &RootedFilesystem{root: root, cwd: "."}, nil
+}
+
+// Closes the root directory.  Later operations fail.
+func (f *RootedFilesystem) Close() error {
+	return f.root.Close()
+}
+
+// Translates name into a path relative to the root.
+func (f *RootedFilesystem) rel(name string) string {
+	if !strings.HasPrefix(name, "/") {
+		f.mu.Lock()
+		name = "/" + f.cwd + "/" + name
+		f.mu.Unlock()
+	}
+	if name = path.Clean(name)[1:]; name == "" {
+		return "."
+	}
+	return name
+}
+
+// Reports err, from an os.Root method, as an error from op on name.
+func rootPathError(op string, name string, err error) error {
+	var perr *fs.PathError
+	if errors.As(err, &perr) {
+		return newPathError(op, name, perr.Err)
+	}
+	return err
+}
+
+func (f *RootedFilesystem) Chdir(dir string) error {
+	rel := f.rel(dir)
+	fi, err := f.root.Stat(rel)
+	if err != nil {
+		return rootPathError("chdir", dir, err)
+	}
+	if !fi.IsDir() {
+		return newPathError("chdir", dir, syscall.ENOTDIR)
+	}
+	f.mu.Lock()
+	f.cwd = rel
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *RootedFilesystem) Getwd() (dir string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return path.Join("/", f.cwd), nil
+}
+
+func (f *RootedFilesystem) Mkdir(name string, perm os.FileMode) error {
+	return rootPathError("mkdir", name, f.root.Mkdir(f.rel(name), perm))
+}
+
+func (f *RootedFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	return rootPathError("mkdir", path, f.root.MkdirAll(f.rel(path), perm))
+}
+
+func (f *RootedFilesystem) Remove(name string) error {
+	return rootPathError("remove", name, f.root.Remove(f.rel(name)))
+}
+
+func (f *RootedFilesystem) RemoveAll(path string) error {
+	rel := f.rel(path)
+	if rel == "." {
+		return newPathError("unlinkat", path, syscall.EBUSY)
+	}
+	return rootPathError("unlinkat", path, f.root.RemoveAll(rel))
+}
+
+func (f *RootedFilesystem) Rename(oldname string, newname string) error {
+	return relink(f.root.Rename(f.rel(oldname), f.rel(newname)), oldname, newname)
+}
+
+func (f *RootedFilesystem) Create(name string) (file File, err error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (f *RootedFilesystem) Open(name string) (file File, err error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (f *RootedFilesystem) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	rel := f.rel(name)
+	osFile, err := f.root.OpenFile(rel, flag, perm)
+	if err != nil {
+		return nil, rootPathError("open", name, err)
+	}
+	return &rootedFile{File: osFile, name: name, rel: rel, filesystem: f}, nil
+}
+
+func (f *RootedFilesystem) Stat(name string) (fi os.FileInfo, err error) {
+	if fi, err = f.root.Stat(f.rel(name)); err != nil {
+		return nil, rootPathError("stat", name, err)
+	}
+	return fi, nil
+}
+
+func (f *RootedFilesystem) Lstat(name string) (fi os.FileInfo, err error) {
+	if fi, err = f.root.Lstat(f.rel(name)); err != nil {
+		return nil, rootPathError("lstat", name, err)
+	}
+	return fi, nil
+}
+
+// Creates newname as a symbolic link to oldname.  The link is created even
+// if oldname points outside the root, but following it fails.
+func (f *RootedFilesystem) Symlink(oldname string, newname string) error {
+	return relink(f.root.Symlink(oldname, f.rel(newname)), oldname, newname)
+}
+
+func (f *RootedFilesystem) Readlink(name string) (string, error) {
+	target, err := f.root.Readlink(f.rel(name))
+	if err != nil {
+		return "", rootPathError("readlink", name, err)
+	}
+	return target, nil
+}
+
+func (f *RootedFilesystem) Link(oldname string, newname string) error {
+	return relink(f.root.Link(f.rel(oldname), f.rel(newname)), oldname, newname)
+}
+
+func (f *RootedFilesystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return rootPathError("chtimes", name, f.root.Chtimes(f.rel(name), atime, mtime))
+}
+
+func (f *RootedFilesystem) Chown(name string, uid int, gid int) error {
+	return rootPathError("chown", name, f.root.Chown(f.rel(name), uid, gid))
+}
+
+func (f *RootedFilesystem) Lchown(name string, uid int, gid int) error {
+	return rootPathError("lchown", name, f.root.Lchown(f.rel(name), uid, gid))
+}
+
+func (f *RootedFilesystem) Chmod(name string, mode os.FileMode) error {
+	return rootPathError("chmod", name, f.root.Chmod(f.rel(name), mode))
+}
+
+func (f *RootedFilesystem) Truncate(name string, size int64) error {
+	osFile, err := f.root.OpenFile(f.rel(name), os.O_WRONLY, 0)
+	if err != nil {
+		return rootPathError("truncate", name, err)
+	}
+	defer osFile.Close()
+	return rootPathError("truncate", name, osFile.Truncate(size))
+}
+
+func (f *RootedFilesystem) ReadDir(name string) ([]os.DirEntry, error) {
+	osFile, err := f.root.Open(f.rel(name))
+	if err != nil {
+		return nil, rootPathError("open", name, err)
+	}
+	defer osFile.Close()
+	entries, err := osFile.ReadDir(-1)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, rootPathError("readdirent", name, err)
+}
+
+func (f *RootedFilesystem) ReadFile(name string) ([]byte, error) {
+	data, err := f.root.ReadFile(f.rel(name))
+	if err != nil {
+		return nil, rootPathError("open", name, err)
+	}
+	return data, nil
+}
+
+func (f *RootedFilesystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return rootPathError("open", name, f.root.WriteFile(f.rel(name), data, perm))
+}
+
+// Returns the next random temporary name, as the os package does.
+func (f *RootedFilesystem) tempName(prefix string, suffix string) string {
+	return prefix + strconv.FormatUint(uint64(rand.Uint32()), 10) + suffix
+}
+
+func (f *RootedFilesystem) MkdirTemp(dir string, pattern string) (string, error) {
+	if dir == "" {
+		dir = f.TempDir()
+	}
+	prefix, suffix, err := tempPattern(dir, pattern)
+	if err != nil {
+		return "", newPathError("mkdirtemp", pattern, err)
+	}
+	for try := 0; try < maxTempTries; try++ {
+		name := f.tempName(prefix, suffix)
+		if err = f.Mkdir(name, 0700); err == nil {
+			return name, nil
+		} else if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+	}
+	return "", newPathError("mkdirtemp", prefix+"*"+suffix, syscall.EEXIST)
+}
+
+func (f *RootedFilesystem) CreateTemp(dir string, pattern string) (file File, err error) {
+	if dir == "" {
+		dir = f.TempDir()
+	}
+	prefix, suffix, err := tempPattern(dir, pattern)
+	if err != nil {
+		return nil, newPathError("createtemp", pattern, err)
+	}
+	for try := 0; try < maxTempTries; try++ {
+		name := f.tempName(prefix, suffix)
+		if file, err = f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600); err == nil {
+			return file, nil
+		} else if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+	}
+	return nil, newPathError("createtemp", prefix+"*"+suffix, syscall.EEXIST)
+}
+
+// Returns "/tmp" within the root.  It is not created automatically.
+func (f *RootedFilesystem) TempDir() string {
+	return "/tmp"
+}
+
+// An *os.File opened through a RootedFilesystem.  Errors report the name
+// the file was opened with rather than its location on disk, and Chdir
+// changes the filesystem's working directory rather than the process's.
+// The descriptor is hidden, as system calls relative to a directory's
+// descriptor could reach outside the root.
+type rootedFile struct {
+	*os.File
+	name       string
+	rel        string
+	filesystem *RootedFilesystem
+}
+
+// Replaces the path of the file on disk in err with the name it was opened
+// with.
+func (f *rootedFile) fix(err error) error {
+	var perr *fs.PathError
+	if errors.As(err, &perr) && perr.Path == f.File.Name() {
+		return newPathError(perr.Op, f.name, perr.Err)
+	}
+	return err
+}
+
+func (f *rootedFile) Chdir() error {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return newPathError("chdir", f.name, underlying(err))
+	}
+	if !fi.IsDir() {
+		return newPathError("chdir", f.name, syscall.ENOTDIR)
+	}
+	f.filesystem.mu.Lock()
+	f.filesystem.cwd = f.rel
+	f.filesystem.mu.Unlock()
+	return nil
+}
+
+func (f *rootedFile) Chmod(mode os.FileMode) error {
+	return f.fix(f.File.Chmod(mode))
+}
+
+func (f *rootedFile) Chown(uid int, gid int) error {
+	return f.fix(f.File.Chown(uid, gid))
+}
+
+func (f *rootedFile) Close() error {
+	return f.fix(f.File.Close())
+}
+
+// Returns ^uintptr(0), as a closed *os.File does.
+func (f *rootedFile) Fd() uintptr {
+	return ^uintptr(0)
+}
+
+func (f *rootedFile) Name() string {
+	return f.name
+}
+
+func (f *rootedFile) Read(b []byte) (n int, err error) {
+	n, err = f.File.Read(b)
+	return n, f.fix(err)
+}
+
+func (f *rootedFile) ReadAt(b []byte, off int64) (n int, err error) {
+	n, err = f.File.ReadAt(b, off)
+	return n, f.fix(err)
+}
+
+func (f *rootedFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := f.File.ReadDir(n)
+	return entries, f.fix(err)
+}
+
+func (f *rootedFile) ReadFrom(r io.Reader) (n int64, err error) {
+	n, err = f.File.ReadFrom(r)
+	return n, f.fix(err)
+}
+
+func (f *rootedFile) Readdir(n int) (fi []os.FileInfo, err error) {
+	fi, err = f.File.Readdir(n)
+	return fi, f.fix(err)
+}
+
+func (f *rootedFile) Readdirnames(n int) (names []string, err error) {
+	names, err = f.File.Readdirnames(n)
+	return names, f.fix(err)
+}
+
+func (f *rootedFile) Stat() (fi os.FileInfo, err error) {
+	fi, err = f.File.Stat()
+	return fi, f.fix(err)
+}
+
+func (f *rootedFile) Sync() (err error) {
+	return f.fix(f.File.Sync())
+}
+
+func (f *rootedFile) SyscallConn() (syscall.RawConn, error) {
+	return nil, newPathError("syscallconn", f.name, errNotSupported)
+}
+
+func (f *rootedFile) Seek(offset int64, whence int) (ret int64, err error) {
+	ret, err = f.File.Seek(offset, whence)
+	return ret, f.fix(err)
+}
+
+func (f *rootedFile) Truncate(size int64) error {
+	return f.fix(f.File.Truncate(size))
+}
+
+func (f *rootedFile) Write(b []byte) (n int, err error) {
+	n, err = f.File.Write(b)
+	return n, f.fix(err)
+}
+
+func (f *rootedFile) WriteAt(b []byte, off int64) (n int, err error) {
+	n, err = f.File.WriteAt(b, off)
+	return n, f.fix(err)
+}
+
+func (f *rootedFile) WriteString(s string) (ret int, err error) {
+	ret, err = f.File.WriteString(s)
+	return ret, f.fix(err)
+}
+
+func (f *rootedFile) WriteTo(w io.Writer) (n int64, err error) {
+	n, err = f.File.WriteTo(w)
+	return n, f.fix(err)
+}
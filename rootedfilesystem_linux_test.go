@@ -0,0 +1,47 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package fauxfile
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestRootedFilesystemDescriptorEscape(t *testing.T) {
+	f, _, secret := newTestRootedFilesystem(t)
+	dir, err := f.Open("/")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer dir.Close()
+	if fd, err := syscall.Openat(int(dir.Fd()), "../secret.txt", os.O_RDONLY, 0); err == nil {
+		syscall.Close(fd)
+		t.Fatalf("Openat relative to File.Fd escaped the root to %v", secret)
+	}
+	conn, err := dir.SyscallConn()
+	if err == nil {
+		conn.Control(func(fd uintptr) {
+			if escaped, err := syscall.Openat(int(fd), "../secret.txt", os.O_RDONLY, 0); err == nil {
+				syscall.Close(escaped)
+				t.Errorf("Openat relative to File.SyscallConn escaped the root to %v", secret)
+			}
+		})
+	}
+	ExpectError(t, errors.ErrUnsupported, err)
+}
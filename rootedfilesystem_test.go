@@ -0,0 +1,179 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Creates a root directory beside a secret file and returns both.
+func newTestRootedFilesystem(t *testing.T) (*RootedFilesystem, string, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Creating symbolic links needs extra privileges on Windows")
+	}
+	tmp := t.TempDir()
+	secret := filepath.Join(tmp, "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	dir := filepath.Join(tmp, "root")
+	os.MkdirAll(filepath.Join(dir, "a", "b"), 0755)
+	os.WriteFile(filepath.Join(dir, "a", "b", "foo.txt"), []byte("foo"), 0644)
+	f, err := NewRootedFilesystem(dir)
+	if err != nil {
+		t.Fatalf("NewRootedFilesystem returned error: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f, dir, secret
+}
+
+func TestRootedFilesystemNames(t *testing.T) {
+	f, dir, _ := newTestRootedFilesystem(t)
+	if err := f.Chdir("/a"); err != nil {
+		t.Fatalf("Chdir returned error: %v", err)
+	}
+	if cwd, _ := f.Getwd(); cwd != "/a" {
+		t.Fatalf("Getwd returned %v, expected /a", cwd)
+	}
+	fi, err := f.Stat("b/foo.txt")
+	if err != nil {
+		t.Fatalf("Stat returned error: %v", err)
+	}
+	if fi.Name() != "foo.txt" {
+		t.Fatalf("Stat().Name() returned %v, expected foo.txt", fi.Name())
+	}
+	if fi, err = f.Stat("/"); err != nil || !fi.IsDir() {
+		t.Fatalf("Stat of the root returned %v, %v", fi, err)
+	}
+	file, err := f.Open("b/foo.txt")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer file.Close()
+	if file.Name() != "b/foo.txt" {
+		t.Fatalf("Name returned %v, expected b/foo.txt", file.Name())
+	}
+	if _, err = file.Write([]byte("x")); err == nil || strings.Contains(err.Error(), dir) {
+		t.Fatalf("Write to a read-only file returned %v", err)
+	}
+	var perr *os.PathError
+	_, err = f.Open("missing.txt")
+	if !errors.As(err, &perr) || perr.Op != "open" || perr.Path != "missing.txt" {
+		t.Fatalf("Expected error to report the relative name, got %v", err)
+	}
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected a not-exist error, got %v", err)
+	}
+}
+
+func TestRootedFilesystemDotDot(t *testing.T) {
+	f, _, _ := newTestRootedFilesystem(t)
+	if err := f.Chdir("../../.."); err != nil {
+		t.Fatalf("Chdir returned error: %v", err)
+	}
+	if cwd, _ := f.Getwd(); cwd != "/" {
+		t.Fatalf("Getwd returned %v, expected /", cwd)
+	}
+	if _, err := f.Stat("../secret.txt"); !os.IsNotExist(err) {
+		t.Fatalf("Stat above the root returned %v, expected not-exist", err)
+	}
+	if data, err := f.ReadFile("/../a/b/../b/foo.txt"); err != nil || string(data) != "foo" {
+		t.Fatalf("ReadFile returned %q, %v", data, err)
+	}
+	file, err := f.Open("a/b")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer file.Close()
+	if err = file.Chdir(); err != nil {
+		t.Fatalf("File.Chdir returned error: %v", err)
+	}
+	if cwd, _ := f.Getwd(); cwd != "/a/b" {
+		t.Fatalf("Getwd returned %v, expected /a/b", cwd)
+	}
+	if err = f.Chdir("foo.txt"); err == nil {
+		t.Fatalf("Chdir to a regular file should fail")
+	}
+}
+
+func TestRootedFilesystemHostileSymlinks(t *testing.T) {
+	f, dir, secret := newTestRootedFilesystem(t)
+	links := map[string]string{
+		"absolute": secret,
+		"parent":   filepath.Join("..", "secret.txt"),
+		"updir":    filepath.Join("..", ".."),
+		"deep":     filepath.Join("a", "b", "..", "..", "..", "secret.txt"),
+		"hostdir":  filepath.Dir(secret),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Symlink returned error: %v", err)
+		}
+	}
+	os.Symlink("../../..", filepath.Join(dir, "a", "b", "up"))
+	names := []string{
+		"absolute",
+		"parent",
+		"updir/secret.txt",
+		"deep",
+		"hostdir/secret.txt",
+		"a/b/up/secret.txt",
+	}
+	for _, name := range names {
+		if data, err := f.ReadFile(name); err == nil {
+			t.Fatalf("ReadFile(%v) escaped the root and read %q", name, data)
+		} else if strings.Contains(err.Error(), dir) {
+			t.Fatalf("ReadFile(%v) leaked the root path: %v", name, err)
+		}
+		if err := f.WriteFile(name, []byte("owned"), 0644); err == nil {
+			t.Fatalf("WriteFile(%v) escaped the root", name)
+		}
+		if _, err := f.Stat(name); err == nil {
+			t.Fatalf("Stat(%v) escaped the root", name)
+		}
+		if err := f.Chmod(name, 0777); err == nil {
+			t.Fatalf("Chmod(%v) escaped the root", name)
+		}
+	}
+	if err := f.Chdir("hostdir"); err == nil {
+		t.Fatalf("Chdir through a symlink out of the root should fail")
+	}
+	if err := f.Symlink("/", "/a/escape"); err != nil {
+		t.Fatalf("Symlink returned error: %v", err)
+	}
+	if _, err := f.Open("/a/escape" + secret); err == nil {
+		t.Fatalf("Open through a new symlink escaped the root")
+	}
+	if target, err := f.Readlink("parent"); err != nil || target != links["parent"] {
+		t.Fatalf("Readlink returned %v, %v", target, err)
+	}
+	if fi, err := f.Lstat("absolute"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Lstat returned %v, %v", fi, err)
+	}
+	if err := f.Remove("absolute"); err != nil {
+		t.Fatalf("Remove of a symlink returned error: %v", err)
+	}
+	if err := f.RemoveAll("updir"); err != nil {
+		t.Fatalf("RemoveAll of a symlink returned error: %v", err)
+	}
+	if data, err := os.ReadFile(secret); err != nil || string(data) != "secret" {
+		t.Fatalf("File outside the root was modified: %q, %v", data, err)
+	}
+}
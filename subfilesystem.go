@@ -0,0 +1,409 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Returns a view of the tree rooted at dir within fsys.  The directory
+// appears as "/", relative names are resolved against a working directory
+// of the view's own, and names are cleaned lexically, so neither ".." nor
+// Chdir can leave it.  Paths in errors, from File.Name and from absolute
+// symbolic links are translated back into the view.  A relative dir is
+// resolved against the working directory of fsys.  The view is not a
+// security boundary: relative symbolic links are followed by fsys and may
+// lead outside dir.  Use a RootedFilesystem to confine access on disk.
+func Sub(fsys Filesystem, dir string) Filesystem {
+	if !path.IsAbs(dir) {
+		if wd, err := fsys.Getwd(); err == nil {
+			dir = path.Join(wd, dir)
+		}
+	}
+	return &subFilesystem{fsys: fsys, dir: path.Clean(dir), cwd: "/"}
+}
+
+type subFilesystem struct {
+	mu   sync.Mutex
+	fsys Filesystem
+	dir  string
+	cwd  string
+}
+
+// Translates name into an absolute path within the view.
+func (s *subFilesystem) subpath(name string) string {
+	if !strings.HasPrefix(name, "/") {
+		s.mu.Lock()
+		name = s.cwd + "/" + name
+		s.mu.Unlock()
+	}
+	return path.Clean(name)
+}
+
+// Translates name into a path within the underlying filesystem.
+func (s *subFilesystem) inner(name string) string {
+	return path.Join(s.dir, s.subpath(name))
+}
+
+// Translates p, a path within the underlying filesystem, back into the
+// view.  The path inner, which the caller knows as name, is reported as
+// name.  Paths outside the view are returned unchanged.
+func (s *subFilesystem) outer(p string, inner string, name string) string {
+	switch {
+	case p == inner:
+		return name
+	case p == s.dir:
+		return "/"
+	case s.dir == "/":
+		return p
+	case strings.HasPrefix(p, s.dir+"/"):
+		return p[len(s.dir):]
+	}
+	return p
+}
+
+// Translates the path in err, an *os.PathError from the underlying
+// filesystem, back into the view.
+func (s *subFilesystem) fix(err error, inner string, name string) error {
+	if perr, ok := err.(*os.PathError); ok {
+		return newPathError(perr.Op, s.outer(perr.Path, inner, name), perr.Err)
+	}
+	return err
+}
+
+func (s *subFilesystem) Chdir(dir string) error {
+	sub := s.subpath(dir)
+	inner := path.Join(s.dir, sub)
+	fi, err := s.fsys.Stat(inner)
+	if err != nil {
+		return newPathError("chdir", dir, underlying(err))
+	}
+	if !fi.IsDir() {
+		return newPathError("chdir", dir, syscall.ENOTDIR)
+	}
+	s.mu.Lock()
+	s.cwd = sub
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *subFilesystem) Getwd() (dir string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cwd, nil
+}
+
+func (s *subFilesystem) Mkdir(name string, perm os.FileMode) error {
+	inner := s.inner(name)
+	return s.fix(s.fsys.Mkdir(inner, perm), inner, name)
+}
+
+func (s *subFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	inner := s.inner(path)
+	return s.fix(s.fsys.MkdirAll(inner, perm), inner, path)
+}
+
+// Removes name, which may not be the root of the view.
+func (s *subFilesystem) Remove(name string) error {
+	inner := s.inner(name)
+	if inner == s.dir {
+		return newPathError("remove", name, syscall.EBUSY)
+	}
+	return s.fix(s.fsys.Remove(inner), inner, name)
+}
+
+// Removes path and its children.  The root of the view may not be removed.
+func (s *subFilesystem) RemoveAll(path string) error {
+	inner := s.inner(path)
+	if inner == s.dir {
+		return newPathError("unlinkat", path, syscall.EBUSY)
+	}
+	return s.fix(s.fsys.RemoveAll(inner), inner, path)
+}
+
+func (s *subFilesystem) Rename(oldname string, newname string) error {
+	return relink(s.fsys.Rename(s.inner(oldname), s.inner(newname)), oldname, newname)
+}
+
+func (s *subFilesystem) Create(name string) (file File, err error) {
+	return s.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (s *subFilesystem) Open(name string) (file File, err error) {
+	return s.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (s *subFilesystem) OpenFile(name string, flag int, perm os.FileMode) (file File, err error) {
+	inner := s.inner(name)
+	if file, err = s.fsys.OpenFile(inner, flag, perm); err != nil {
+		return nil, s.fix(err, inner, name)
+	}
+	return s.wrap(file, inner, name), nil
+}
+
+// Wraps file, opened at inner, as a File named name within the view.
+func (s *subFilesystem) wrap(file File, inner string, name string) File {
+	return &subFile{
+		File:       file,
+		name:       s.outer(file.Name(), inner, name),
+		inner:      inner,
+		filesystem: s,
+	}
+}
+
+func (s *subFilesystem) Stat(name string) (fi os.FileInfo, err error) {
+	inner := s.inner(name)
+	if fi, err = s.fsys.Stat(inner); err != nil {
+		return nil, s.fix(err, inner, name)
+	}
+	return fi, nil
+}
+
+func (s *subFilesystem) Lstat(name string) (fi os.FileInfo, err error) {
+	inner := s.inner(name)
+	if fi, err = s.fsys.Lstat(inner); err != nil {
+		return nil, s.fix(err, inner, name)
+	}
+	return fi, nil
+}
+
+// Creates newname as a symbolic link to oldname.  An absolute oldname is
+// taken to be within the view.
+func (s *subFilesystem) Symlink(oldname string, newname string) error {
+	target := oldname
+	if path.IsAbs(target) {
+		target = path.Join(s.dir, target)
+	}
+	return relink(s.fsys.Symlink(target, s.inner(newname)), oldname, newname)
+}
+
+func (s *subFilesystem) Readlink(name string) (string, error) {
+	inner := s.inner(name)
+	target, err := s.fsys.Readlink(inner)
+	if err != nil {
+		return "", s.fix(err, inner, name)
+	}
+	if path.IsAbs(target) {
+		target = s.outer(target, "", "")
+	}
+	return target, nil
+}
+
+func (s *subFilesystem) Link(oldname string, newname string) error {
+	return relink(s.fsys.Link(s.inner(oldname), s.inner(newname)), oldname, newname)
+}
+
+func (s *subFilesystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	inner := s.inner(name)
+	return s.fix(s.fsys.Chtimes(inner, atime, mtime), inner, name)
+}
+
+func (s *subFilesystem) Chown(name string, uid int, gid int) error {
+	inner := s.inner(name)
+	return s.fix(s.fsys.Chown(inner, uid, gid), inner, name)
+}
+
+func (s *subFilesystem) Lchown(name string, uid int, gid int) error {
+	inner := s.inner(name)
+	return s.fix(s.fsys.Lchown(inner, uid, gid), inner, name)
+}
+
+func (s *subFilesystem) Chmod(name string, mode os.FileMode) error {
+	inner := s.inner(name)
+	return s.fix(s.fsys.Chmod(inner, mode), inner, name)
+}
+
+func (s *subFilesystem) Truncate(name string, size int64) error {
+	inner := s.inner(name)
+	return s.fix(s.fsys.Truncate(inner, size), inner, name)
+}
+
+func (s *subFilesystem) ReadDir(name string) ([]os.DirEntry, error) {
+	inner := s.inner(name)
+	entries, err := s.fsys.ReadDir(inner)
+	return entries, s.fix(err, inner, name)
+}
+
+func (s *subFilesystem) ReadFile(name string) ([]byte, error) {
+	inner := s.inner(name)
+	data, err := s.fsys.ReadFile(inner)
+	return data, s.fix(err, inner, name)
+}
+
+func (s *subFilesystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	inner := s.inner(name)
+	return s.fix(s.fsys.WriteFile(inner, data, perm), inner, name)
+}
+
+func (s *subFilesystem) MkdirTemp(dir string, pattern string) (string, error) {
+	if dir == "" {
+		dir = s.TempDir()
+	}
+	inner := s.inner(dir)
+	name, err := s.fsys.MkdirTemp(inner, pattern)
+	if err != nil {
+		return "", s.fix(err, inner, dir)
+	}
+	return path.Join(dir, path.Base(name)), nil
+}
+
+func (s *subFilesystem) CreateTemp(dir string, pattern string) (file File, err error) {
+	if dir == "" {
+		dir = s.TempDir()
+	}
+	inner := s.inner(dir)
+	if file, err = s.fsys.CreateTemp(inner, pattern); err != nil {
+		return nil, s.fix(err, inner, dir)
+	}
+	name := path.Base(file.Name())
+	return s.wrap(file, path.Join(inner, name), path.Join(dir, name)), nil
+}
+
+// Returns "/tmp" within the view.  It is not created automatically.
+func (s *subFilesystem) TempDir() string {
+	return "/tmp"
+}
+
+// A File opened through a view returned by Sub.
+type subFile struct {
+	File
+	name       string
+	inner      string
+	filesystem *subFilesystem
+}
+
+func (f *subFile) fix(err error) error {
+	return f.filesystem.fix(err, f.inner, f.name)
+}
+
+// Makes the file, which must be a directory, the working directory of the
+// view.
+func (f *subFile) Chdir() error {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return newPathError("chdir", f.name, underlying(err))
+	}
+	if !fi.IsDir() {
+		return newPathError("chdir", f.name, syscall.ENOTDIR)
+	}
+	sub := f.filesystem.outer(f.inner, "", "")
+	f.filesystem.mu.Lock()
+	f.filesystem.cwd = sub
+	f.filesystem.mu.Unlock()
+	return nil
+}
+
+func (f *subFile) Chmod(mode os.FileMode) error {
+	return f.fix(f.File.Chmod(mode))
+}
+
+func (f *subFile) Chown(uid int, gid int) error {
+	return f.fix(f.File.Chown(uid, gid))
+}
+
+func (f *subFile) Close() error {
+	return f.fix(f.File.Close())
+}
+
+func (f *subFile) Name() string {
+	return f.name
+}
+
+func (f *subFile) Read(b []byte) (n int, err error) {
+	n, err = f.File.Read(b)
+	return n, f.fix(err)
+}
+
+func (f *subFile) ReadAt(b []byte, off int64) (n int, err error) {
+	n, err = f.File.ReadAt(b, off)
+	return n, f.fix(err)
+}
+
+func (f *subFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := f.File.ReadDir(n)
+	return entries, f.fix(err)
+}
+
+func (f *subFile) ReadFrom(r io.Reader) (n int64, err error) {
+	n, err = f.File.ReadFrom(r)
+	return n, f.fix(err)
+}
+
+func (f *subFile) Readdir(n int) (fi []os.FileInfo, err error) {
+	fi, err = f.File.Readdir(n)
+	return fi, f.fix(err)
+}
+
+func (f *subFile) Readdirnames(n int) (names []string, err error) {
+	names, err = f.File.Readdirnames(n)
+	return names, f.fix(err)
+}
+
+func (f *subFile) SetDeadline(t time.Time) error {
+	return f.fix(f.File.SetDeadline(t))
+}
+
+func (f *subFile) SetReadDeadline(t time.Time) error {
+	return f.fix(f.File.SetReadDeadline(t))
+}
+
+func (f *subFile) SetWriteDeadline(t time.Time) error {
+	return f.fix(f.File.SetWriteDeadline(t))
+}
+
+func (f *subFile) Stat() (fi os.FileInfo, err error) {
+	fi, err = f.File.Stat()
+	return fi, f.fix(err)
+}
+
+func (f *subFile) Sync() (err error) {
+	return f.fix(f.File.Sync())
+}
+
+func (f *subFile) Seek(offset int64, whence int) (ret int64, err error) {
+	ret, err = f.File.Seek(offset, whence)
+	return ret, f.fix(err)
+}
+
+func (f *subFile) Truncate(size int64) error {
+	return f.fix(f.File.Truncate(size))
+}
+
+func (f *subFile) Write(b []byte) (n int, err error) {
+	n, err = f.File.Write(b)
+	return n, f.fix(err)
+}
+
+func (f *subFile) WriteAt(b []byte, off int64) (n int, err error) {
+	n, err = f.File.WriteAt(b, off)
+	return n, f.fix(err)
+}
+
+func (f *subFile) WriteString(s string) (ret int, err error) {
+	ret, err = f.File.WriteString(s)
+	return ret, f.fix(err)
+}
+
+func (f *subFile) WriteTo(w io.Writer) (n int64, err error) {
+	n, err = f.File.WriteTo(w)
+	return n, f.fix(err)
+}
@@ -0,0 +1,138 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func newTestSub(t *testing.T) (*MockFilesystem, Filesystem) {
+	mf := NewMockFilesystem()
+	mf.MkdirAll("/srv/app/config", 0755)
+	mf.WriteFile("/srv/app/config/app.json", []byte("{}"), 0644)
+	mf.WriteFile("/srv/secret.txt", []byte("secret"), 0644)
+	return mf, Sub(mf, "/srv/app")
+}
+
+func TestSubNames(t *testing.T) {
+	mf, sub := newTestSub(t)
+	if data, err := sub.ReadFile("/config/app.json"); err != nil || string(data) != "{}" {
+		t.Fatalf("ReadFile returned %q, %v", data, err)
+	}
+	if err := sub.WriteFile("/config/new.json", []byte("[]"), 0644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	if _, err := mf.Stat("/srv/app/config/new.json"); err != nil {
+		t.Fatalf("WriteFile did not write beneath the root: %v", err)
+	}
+	file, err := sub.Open("/config/app.json")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer file.Close()
+	if file.Name() != "/config/app.json" {
+		t.Fatalf("Name returned %v, expected /config/app.json", file.Name())
+	}
+	_, err = sub.Open("/config/missing.json")
+	if perr, ok := err.(*os.PathError); !ok || perr.Path != "/config/missing.json" {
+		t.Fatalf("Expected error to report the name within the view, got %v", err)
+	}
+	err = sub.MkdirAll("/config/app.json/x", 0755)
+	if perr, ok := err.(*os.PathError); !ok || perr.Path != "/config/app.json" {
+		t.Fatalf("Expected error to report the path within the view, got %v", err)
+	}
+	if err = sub.Symlink("/config/app.json", "/link"); err != nil {
+		t.Fatalf("Symlink returned error: %v", err)
+	}
+	if target, err := mf.Readlink("/srv/app/link"); err != nil || target != "/srv/app/config/app.json" {
+		t.Fatalf("Underlying Readlink returned %v, %v", target, err)
+	}
+	if target, err := sub.Readlink("/link"); err != nil || target != "/config/app.json" {
+		t.Fatalf("Readlink returned %v, %v", target, err)
+	}
+	if data, err := sub.ReadFile("/link"); err != nil || string(data) != "{}" {
+		t.Fatalf("ReadFile through a symlink returned %q, %v", data, err)
+	}
+}
+
+func TestSubChdir(t *testing.T) {
+	mf, sub := newTestSub(t)
+	if err := sub.Chdir("../../.."); err != nil {
+		t.Fatalf("Chdir returned error: %v", err)
+	}
+	if cwd, _ := sub.Getwd(); cwd != "/" {
+		t.Fatalf("Getwd returned %v, expected /", cwd)
+	}
+	if _, err := sub.Stat("../secret.txt"); !os.IsNotExist(err) {
+		t.Fatalf("Stat above the root returned %v, expected not-exist", err)
+	}
+	if err := sub.Chdir("config"); err != nil {
+		t.Fatalf("Chdir returned error: %v", err)
+	}
+	if cwd, _ := sub.Getwd(); cwd != "/config" {
+		t.Fatalf("Getwd returned %v, expected /config", cwd)
+	}
+	if cwd, _ := mf.Getwd(); cwd != "/" {
+		t.Fatalf("Chdir changed the underlying working directory to %v", cwd)
+	}
+	if err := sub.Chdir("app.json"); err == nil {
+		t.Fatalf("Chdir to a regular file should fail")
+	}
+	file, err := sub.Create("new.json")
+	if err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+	file.Close()
+	if file.Name() != "new.json" {
+		t.Fatalf("Name returned %v, expected new.json", file.Name())
+	}
+	if _, err = mf.Stat("/srv/app/config/new.json"); err != nil {
+		t.Fatalf("Relative Create did not use the working directory: %v", err)
+	}
+	root, err := sub.Open("/")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer root.Close()
+	if err = root.Chdir(); err != nil {
+		t.Fatalf("File.Chdir returned error: %v", err)
+	}
+	if cwd, _ := sub.Getwd(); cwd != "/" {
+		t.Fatalf("Getwd returned %v, expected /", cwd)
+	}
+}
+
+func TestSubRemoveRoot(t *testing.T) {
+	mf, sub := newTestSub(t)
+	for _, err := range []error{sub.RemoveAll("/"), sub.Remove(".")} {
+		if perr, ok := err.(*os.PathError); !ok || perr.Err != syscall.EBUSY {
+			t.Fatalf("Expected EBUSY removing the root, got %v", err)
+		}
+	}
+	if _, err := mf.Stat("/srv/app"); err != nil {
+		t.Fatalf("The root was removed: %v", err)
+	}
+}
+
+func TestSubRelativeDir(t *testing.T) {
+	mf, _ := newTestSub(t)
+	mf.Chdir("/srv")
+	sub := Sub(mf, "app/config")
+	if _, err := sub.Stat("/app.json"); err != nil {
+		t.Fatalf("Stat returned error: %v", err)
+	}
+}
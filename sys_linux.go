@@ -0,0 +1,86 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package fauxfile
+
+import (
+	"os"
+	"syscall"
+)
+
+// The block size reported by MockFileInfo.Sys.
+const mockBlockSize = 4096
+
+// Returns a *syscall.Stat_t, matching the value returned by RealFilesystem,
+// so that code which inspects inode numbers, link counts, ownership or
+// timestamps works against either.
+func (mfi *MockFileInfo) Sys() interface{} {
+	size := mfi.Size()
+	st := &syscall.Stat_t{
+		Ino:    mfi.ino,
+		Mode:   unixMode(mfi.mode),
+		Uid:    uint32(mfi.uid),
+		Gid:    uint32(mfi.gid),
+		Size:   size,
+		Blocks: (size + mockBlockSize - 1) / mockBlockSize * (mockBlockSize / 512),
+		Atim:   syscall.NsecToTimespec(mfi.atime.UnixNano()),
+		Mtim:   syscall.NsecToTimespec(mfi.mtime.UnixNano()),
+		Ctim:   syscall.NsecToTimespec(mfi.ctime.UnixNano()),
+	}
+	setStatField(&st.Dev, int64(mfi.filesystem.dev))
+	setStatField(&st.Nlink, int64(mfi.nlink))
+	setStatField(&st.Blksize, mockBlockSize)
+	return st
+}
+
+// Assigns value to a syscall.Stat_t field whose type varies between
+// architectures.
+func setStatField[T ~int32 | ~int64 | ~uint32 | ~uint64](field *T, value int64) {
+	*field = T(value)
+}
+
+// Converts mode to the st_mode bits used by Linux.
+func unixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	switch {
+	case mode.IsDir():
+		m |= syscall.S_IFDIR
+	case mode&os.ModeSymlink != 0:
+		m |= syscall.S_IFLNK
+	case mode&os.ModeNamedPipe != 0:
+		m |= syscall.S_IFIFO
+	default:
+		m |= syscall.S_IFREG
+	}
+	if mode&os.ModeSetuid != 0 {
+		m |= syscall.S_ISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= syscall.S_ISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= syscall.S_ISVTX
+	}
+	return m
+}
+
+// Returns the owner of the file described by fi, if Sys reports it.
+func fileOwner(fi os.FileInfo) (uid int, gid int, ok bool) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid), true
+	}
+	return 0, 0, false
+}
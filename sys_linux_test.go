@@ -0,0 +1,97 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package fauxfile
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func ExpectNlink(t *testing.T, expected int, fi os.FileInfo) {
+	if nlink := fi.Sys().(*syscall.Stat_t).Nlink; int(nlink) != expected {
+		t.Fatalf("Link count %v, expected %v", nlink, expected)
+	}
+}
+
+func TestSysTimes(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 5))
+	mf := NewMockFilesystem(WithClock(clock))
+	f, _ := mf.Create("foo.txt")
+	clock.Advance(time.Second)
+	f.Write([]byte("Hello"))
+	fi, _ := f.Stat()
+	st := fi.Sys().(*syscall.Stat_t)
+	if st.Atim.Sec != 1000 || st.Atim.Nsec != 5 {
+		t.Fatalf("Atim %v, expected 1000.000000005", st.Atim)
+	}
+	if st.Mtim.Sec != 1001 || st.Ctim.Sec != 1001 {
+		t.Fatalf("Mtim %v and Ctim %v, expected 1001", st.Mtim, st.Ctim)
+	}
+}
+
+func TestSysStat(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Mkdir("foo", 0755)
+	f, _ := mf.Create("foo/a.txt")
+	f.Write(make([]byte, 5000))
+	mf.Chtimes("foo/a.txt", time.Unix(1, 0), time.Unix(2, 0))
+	fi, _ := mf.Stat("foo/a.txt")
+	st := fi.Sys().(*syscall.Stat_t)
+	if st.Size != 5000 || st.Blocks != 16 || st.Blksize != 4096 {
+		t.Fatalf("Size %v, blocks %v, blksize %v", st.Size, st.Blocks, st.Blksize)
+	}
+	if st.Mode != syscall.S_IFREG|0644 {
+		t.Fatalf("Mode %o, expected %o", st.Mode, syscall.S_IFREG|0644)
+	}
+	if st.Nlink != 1 || st.Uid != 0 || st.Gid != 0 {
+		t.Fatalf("Nlink %v, uid %v, gid %v", st.Nlink, st.Uid, st.Gid)
+	}
+	dir, _ := mf.Stat("foo")
+	if mode := dir.Sys().(*syscall.Stat_t).Mode; mode != syscall.S_IFDIR|0755 {
+		t.Fatalf("Mode %o, expected %o", mode, syscall.S_IFDIR|0755)
+	}
+	if dir.Sys().(*syscall.Stat_t).Ino == st.Ino {
+		t.Fatalf("Inode numbers should be unique")
+	}
+}
+
+func TestSameFileReal(t *testing.T) {
+	fs := &RealFilesystem{}
+	dir := t.TempDir()
+	f, _ := fs.Create(filepath.Join(dir, "a.txt"))
+	f.Close()
+	fs.Link(filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"))
+	a, _ := fs.Stat(filepath.Join(dir, "a.txt"))
+	b, _ := fs.Stat(filepath.Join(dir, "b.txt"))
+	if !SameFile(a, b) {
+		t.Fatalf("Links should be the same file")
+	}
+}
+
+func TestSysOwner(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Create("foo.txt")
+	mf.Chown("foo.txt", 1000, 100)
+	fi, _ := mf.Stat("foo.txt")
+	st := fi.Sys().(*syscall.Stat_t)
+	if st.Uid != 1000 || st.Gid != 100 {
+		t.Fatalf("Uid %v, gid %v, expected 1000, 100", st.Uid, st.Gid)
+	}
+}
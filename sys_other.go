@@ -0,0 +1,37 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package fauxfile
+
+import (
+	"os"
+)
+
+// Returns nil.  Stat_t emulation is only provided on Linux, so elsewhere
+// the inode number, link count, owner and access and change times of a
+// mock file are not available.  Use SameFile to compare files.
+func (mfi *MockFileInfo) Sys() interface{} {
+	return nil
+}
+
+// Returns the owner of the file described by fi.  Only the owners of files
+// in a MockFilesystem are known.
+func fileOwner(fi os.FileInfo) (uid int, gid int, ok bool) {
+	if mfi, ok := fi.(*MockFileInfo); ok {
+		return mfi.uid, mfi.gid, true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,171 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Flags for Setxattr, with the values of the Linux XATTR_CREATE and
+// XATTR_REPLACE flags.  A missing attribute is reported as ENODATA, or as
+// ENOATTR on the BSDs.
+const (
+	XattrCreate  = 0x1 // Fail with EEXIST if the attribute already exists.
+	XattrReplace = 0x2 // Fail if the attribute does not exist.
+)
+
+// Limits on extended attribute names and values, matching Linux.
+const (
+	maxXattrName  = 255
+	maxXattrValue = 64 * 1024
+)
+
+// Implemented by filesystems which support extended attributes.  Names
+// must carry a namespace prefix such as "user.".  Symbolic links are
+// followed.
+type XattrFilesystem interface {
+	Getxattr(path string, name string) (value []byte, err error)
+	Setxattr(path string, name string, value []byte, flags int) error
+	Listxattr(path string) (names []string, err error)
+	Removexattr(path string, name string) error
+}
+
+// Checks that name is in a namespace the current user may access on fi,
+// wanting either permRead or permWrite.  Names in the trusted namespace
+// are hidden from everyone but root.
+func (mf *MockFilesystem) xattrAccess(fi *MockFileInfo, name string, want os.FileMode) error {
+	if len(name) > maxXattrName {
+		return errRange
+	}
+	root := mf.creds == nil || mf.creds.Uid == 0
+	switch {
+	case strings.HasPrefix(name, "user.") && len(name) > len("user."):
+		if !fi.IsDir() && !fi.mode.IsRegular() {
+			if want == permWrite {
+				return syscall.EPERM
+			}
+			return errNoData
+		}
+		return mf.access(fi, want)
+	case strings.HasPrefix(name, "trusted.") && len(name) > len("trusted."):
+		if !root {
+			if want == permWrite {
+				return syscall.EPERM
+			}
+			return errNoData
+		}
+	case strings.HasPrefix(name, "security.") && len(name) > len("security."),
+		strings.HasPrefix(name, "system.") && len(name) > len("system."):
+		if want == permWrite && !mf.isOwner(fi) {
+			return syscall.EPERM
+		}
+	default:
+		return errNotSupported
+	}
+	return nil
+}
+
+// Returns the value of the named extended attribute of the file at path.
+func (mf *MockFilesystem) Getxattr(path string, name string) (value []byte, err error) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	var fi *MockFileInfo
+	if fi, err = mf.resolve(path); err != nil {
+		return nil, newPathError("getxattr", path, err)
+	}
+	if err = mf.xattrAccess(fi, name, permRead); err != nil {
+		return nil, newPathError("getxattr", path, err)
+	}
+	stored, ok := fi.xattrs[name]
+	if !ok {
+		return nil, newPathError("getxattr", path, errNoData)
+	}
+	return append([]byte{}, stored...), nil
+}
+
+// Sets the named extended attribute of the file at path.  Flags may be
+// XattrCreate or XattrReplace to require that the attribute does not, or
+// does, already exist.
+func (mf *MockFilesystem) Setxattr(path string, name string, value []byte, flags int) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	fi, err := mf.resolve(path)
+	if err != nil {
+		return newPathError("setxattr", path, err)
+	}
+	if flags&^(XattrCreate|XattrReplace) != 0 || flags == XattrCreate|XattrReplace {
+		return newPathError("setxattr", path, syscall.EINVAL)
+	}
+	if err = mf.xattrAccess(fi, name, permWrite); err != nil {
+		return newPathError("setxattr", path, err)
+	}
+	if len(value) > maxXattrValue {
+		return newPathError("setxattr", path, errTooBig)
+	}
+	_, exists := fi.xattrs[name]
+	if exists && flags&XattrCreate != 0 {
+		return newPathError("setxattr", path, syscall.EEXIST)
+	}
+	if !exists && flags&XattrReplace != 0 {
+		return newPathError("setxattr", path, errNoData)
+	}
+	if fi.xattrs == nil {
+		fi.xattrs = map[string][]byte{}
+	}
+	fi.xattrs[name] = append([]byte{}, value...)
+	fi.changed(mf.clock.Now())
+	return nil
+}
+
+// Returns the sorted names of the extended attributes of the file at path
+// which the current user may see.
+func (mf *MockFilesystem) Listxattr(path string) (names []string, err error) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	var fi *MockFileInfo
+	if fi, err = mf.resolve(path); err != nil {
+		return nil, newPathError("listxattr", path, err)
+	}
+	names = []string{}
+	for name := range fi.xattrs {
+		if mf.xattrAccess(fi, name, 0) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Removes the named extended attribute from the file at path.
+func (mf *MockFilesystem) Removexattr(path string, name string) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	fi, err := mf.resolve(path)
+	if err != nil {
+		return newPathError("removexattr", path, err)
+	}
+	if err = mf.xattrAccess(fi, name, permWrite); err != nil {
+		return newPathError("removexattr", path, err)
+	}
+	if _, ok := fi.xattrs[name]; !ok {
+		return newPathError("removexattr", path, errNoData)
+	}
+	delete(fi.xattrs, name)
+	fi.changed(mf.clock.Now())
+	return nil
+}
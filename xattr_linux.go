@@ -0,0 +1,85 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package fauxfile
+
+import (
+	"sort"
+	"strings"
+	"syscall"
+)
+
+func (f *RealFilesystem) Getxattr(path string, name string) (value []byte, err error) {
+	fullpath, _ := f.resolve(path)
+	for {
+		var size int
+		if size, err = syscall.Getxattr(fullpath, name, nil); err != nil {
+			return nil, newPathError("getxattr", path, err)
+		}
+		value = make([]byte, size)
+		if size, err = syscall.Getxattr(fullpath, name, value); err == syscall.ERANGE {
+			// The attribute grew between the two calls.
+			continue
+		} else if err != nil {
+			return nil, newPathError("getxattr", path, err)
+		}
+		return value[:size], nil
+	}
+}
+
+func (f *RealFilesystem) Setxattr(path string, name string, value []byte, flags int) error {
+	fullpath, _ := f.resolve(path)
+	if err := syscall.Setxattr(fullpath, name, value, flags); err != nil {
+		return newPathError("setxattr", path, err)
+	}
+	return nil
+}
+
+func (f *RealFilesystem) Listxattr(path string) (names []string, err error) {
+	fullpath, _ := f.resolve(path)
+	var (
+		buf  []byte
+		size int
+	)
+	for {
+		if size, err = syscall.Listxattr(fullpath, nil); err != nil {
+			return nil, newPathError("listxattr", path, err)
+		}
+		buf = make([]byte, size)
+		if size, err = syscall.Listxattr(fullpath, buf); err == syscall.ERANGE {
+			continue
+		} else if err != nil {
+			return nil, newPathError("listxattr", path, err)
+		}
+		break
+	}
+	names = []string{}
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (f *RealFilesystem) Removexattr(path string, name string) error {
+	fullpath, _ := f.resolve(path)
+	if err := syscall.Removexattr(fullpath, name); err != nil {
+		return newPathError("removexattr", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package fauxfile
+
+import (
+	"errors"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestRealXattr(t *testing.T) {
+	var (
+		fs   = &RealFilesystem{}
+		path = filepath.Join(t.TempDir(), "foo.txt")
+	)
+	f, err := fs.Create(path)
+	if err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+	f.Close()
+	err = fs.Setxattr(path, "user.hash", []byte("abc"), XattrCreate)
+	if errors.Is(err, syscall.ENOTSUP) {
+		t.Skipf("Temporary directory does not support user attributes: %v", err)
+	} else if err != nil {
+		t.Fatalf("Setxattr returned error: %v", err)
+	}
+	ExpectXattr(t, "abc", fs, path, "user.hash")
+	ExpectXattrNames(t, "user.hash", fs, path)
+	err = fs.Setxattr(path, "user.hash", []byte("def"), XattrCreate)
+	ExpectPathError(t, "setxattr", path, syscall.EEXIST, err)
+	if err = fs.Removexattr(path, "user.hash"); err != nil {
+		t.Fatalf("Removexattr returned error: %v", err)
+	}
+	_, err = fs.Getxattr(path, "user.hash")
+	ExpectPathError(t, "getxattr", path, syscall.ENODATA, err)
+	ExpectXattrNames(t, "", fs, path)
+}
@@ -0,0 +1,35 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package fauxfile
+
+// Extended attributes are only supported on Linux.
+
+func (f *RealFilesystem) Getxattr(path string, name string) (value []byte, err error) {
+	return nil, newPathError("getxattr", path, errNotSupported)
+}
+
+func (f *RealFilesystem) Setxattr(path string, name string, value []byte, flags int) error {
+	return newPathError("setxattr", path, errNotSupported)
+}
+
+func (f *RealFilesystem) Listxattr(path string) (names []string, err error) {
+	return nil, newPathError("listxattr", path, errNotSupported)
+}
+
+func (f *RealFilesystem) Removexattr(path string, name string) error {
+	return newPathError("removexattr", path, errNotSupported)
+}
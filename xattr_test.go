@@ -0,0 +1,124 @@
+// Copyright 2012 Arne Roomann-Kurrik
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fauxfile
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func ExpectXattr(t *testing.T, expected string, fs XattrFilesystem, path string, name string) {
+	value, err := fs.Getxattr(path, name)
+	if err != nil {
+		t.Fatalf("Getxattr %v %v returned error: %v", path, name, err)
+	}
+	if string(value) != expected {
+		t.Fatalf("Attribute %v of %v is %q, expected %q", name, path, value, expected)
+	}
+}
+
+func ExpectXattrNames(t *testing.T, expected string, fs XattrFilesystem, path string) {
+	names, err := fs.Listxattr(path)
+	if err != nil {
+		t.Fatalf("Listxattr %v returned error: %v", path, err)
+	}
+	if actual := strings.Join(names, ","); actual != expected {
+		t.Fatalf("Attributes of %v are %v, expected %v", path, actual, expected)
+	}
+}
+
+func TestXattr(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Create("foo.txt")
+	ExpectXattrNames(t, "", mf, "foo.txt")
+	if err := mf.Setxattr("foo.txt", "user.hash", []byte("abc"), 0); err != nil {
+		t.Fatalf("Setxattr returned error: %v", err)
+	}
+	mf.Setxattr("foo.txt", "user.origin", []byte("build"), 0)
+	ExpectXattr(t, "abc", mf, "foo.txt", "user.hash")
+	ExpectXattrNames(t, "user.hash,user.origin", mf, "foo.txt")
+	if err := mf.Removexattr("foo.txt", "user.hash"); err != nil {
+		t.Fatalf("Removexattr returned error: %v", err)
+	}
+	_, err := mf.Getxattr("foo.txt", "user.hash")
+	ExpectPathError(t, "getxattr", "foo.txt", errNoData, err)
+	ExpectPathError(t, "removexattr", "foo.txt", errNoData, mf.Removexattr("foo.txt", "user.hash"))
+	ExpectXattrNames(t, "user.origin", mf, "foo.txt")
+}
+
+func TestXattrFlags(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Create("foo.txt")
+	err := mf.Setxattr("foo.txt", "user.a", []byte("1"), XattrReplace)
+	ExpectPathError(t, "setxattr", "foo.txt", errNoData, err)
+	if err = mf.Setxattr("foo.txt", "user.a", []byte("1"), XattrCreate); err != nil {
+		t.Fatalf("XattrCreate of a new attribute returned error: %v", err)
+	}
+	err = mf.Setxattr("foo.txt", "user.a", []byte("2"), XattrCreate)
+	ExpectPathError(t, "setxattr", "foo.txt", syscall.EEXIST, err)
+	if err = mf.Setxattr("foo.txt", "user.a", []byte("3"), XattrReplace); err != nil {
+		t.Fatalf("XattrReplace of an existing attribute returned error: %v", err)
+	}
+	ExpectXattr(t, "3", mf, "foo.txt", "user.a")
+}
+
+func TestXattrErrors(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Create("foo.txt")
+	err := mf.Setxattr("foo.txt", "hash", []byte("abc"), 0)
+	ExpectPathError(t, "setxattr", "foo.txt", errNotSupported, err)
+	err = mf.Setxattr("foo.txt", "user.", []byte("abc"), 0)
+	ExpectPathError(t, "setxattr", "foo.txt", errNotSupported, err)
+	err = mf.Setxattr("foo.txt", "user."+strings.Repeat("a", 251), nil, 0)
+	ExpectPathError(t, "setxattr", "foo.txt", errRange, err)
+	err = mf.Setxattr("foo.txt", "user.a", make([]byte, 64*1024+1), 0)
+	ExpectPathError(t, "setxattr", "foo.txt", errTooBig, err)
+	_, err = mf.Getxattr("bar.txt", "user.a")
+	ExpectPathError(t, "getxattr", "bar.txt", os.ErrNotExist, err)
+}
+
+func TestXattrFollowsNode(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Create("foo.txt")
+	mf.Setxattr("foo.txt", "user.hash", []byte("abc"), 0)
+	mf.Rename("foo.txt", "bar.txt")
+	ExpectXattr(t, "abc", mf, "bar.txt", "user.hash")
+	mf.Link("bar.txt", "baz.txt")
+	mf.Setxattr("baz.txt", "user.origin", []byte("build"), 0)
+	ExpectXattrNames(t, "user.hash,user.origin", mf, "bar.txt")
+	mf.Symlink("bar.txt", "link")
+	ExpectXattr(t, "abc", mf, "link", "user.hash")
+	value, _ := mf.Getxattr("bar.txt", "user.hash")
+	value[0] = 'x'
+	ExpectXattr(t, "abc", mf, "bar.txt", "user.hash")
+}
+
+func TestXattrPermission(t *testing.T) {
+	mf := NewMockFilesystem()
+	mf.Create("foo.txt")
+	mf.Setxattr("foo.txt", "user.a", []byte("1"), 0)
+	mf.Setxattr("foo.txt", "trusted.b", []byte("2"), 0)
+	mf.SetCredentials(&Credentials{Uid: 1000, Gid: 100})
+	ExpectXattr(t, "1", mf, "foo.txt", "user.a")
+	ExpectXattrNames(t, "user.a", mf, "foo.txt")
+	_, err := mf.Getxattr("foo.txt", "trusted.b")
+	ExpectPathError(t, "getxattr", "foo.txt", errNoData, err)
+	err = mf.Setxattr("foo.txt", "user.a", []byte("3"), 0)
+	ExpectPathError(t, "setxattr", "foo.txt", syscall.EACCES, err)
+	err = mf.Setxattr("foo.txt", "trusted.b", []byte("3"), 0)
+	ExpectPathError(t, "setxattr", "foo.txt", syscall.EPERM, err)
+}